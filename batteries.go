@@ -0,0 +1,107 @@
+package main
+
+import (
+	"github.com/rs/zerolog"
+)
+
+// A Battery generates the list of benchmarks that make up a series.
+type Battery func(logger zerolog.Logger) ([]Benchmark, error)
+
+// Batteries contains all the batteries that can be selected from the command line.
+var Batteries = map[string]Battery{
+	"battery03": battery03GenerateBenchmarks,
+	"battery04": battery04GenerateBenchmarks,
+	"battery05": battery05GenerateBenchmarks,
+}
+
+// Battery05: Check how the difference changes as the average bid size grows.
+//
+// Bat05-02 - is 100 to 1000 with 100 in step.
+// Bat05-03 - is 100 to 10000 with 200 in step.
+func battery05GenerateBenchmarks(logger zerolog.Logger) ([]Benchmark, error) {
+	baseBench := Benchmark{
+		FlinkMaster:   "[local]",
+		NumEvents:     IntPtr(MAX_EVENTS * 5),
+		CoderStrategy: "HAND",
+		Parallelism:   8,
+		Query:         CurrencyConversionQuery,
+	}
+
+	var benches []Benchmark
+	mutator := VaryAvgBidSize(DefaultAverageBidByteSize, 100*DefaultAverageBidByteSize+1, 2*DefaultAverageBidByteSize)(
+		SwapFasterCopy(
+			RepeatRuns(10)(
+				ArrayBench(&benches),
+			),
+		),
+	)
+
+	if err := mutator(logger, baseBench); err != nil {
+		return nil, err
+	}
+
+	return benches, nil
+}
+
+// Battery04: Check how the difference changes parallelism is changed
+func battery04GenerateBenchmarks(logger zerolog.Logger) ([]Benchmark, error) {
+	baseBench := Benchmark{
+		FlinkMaster:   "[local]",
+		NumEvents:     IntPtr(MAX_EVENTS * 5),
+		CoderStrategy: "HAND",
+	}
+
+	var benches []Benchmark
+	mutator := UseParallelism([]int{1, 2, 4, 8})(
+		VaryQuery(NormalQueries)(
+			SwapFasterCopy(
+				RepeatRuns(10)(
+					ArrayBench(&benches),
+				),
+			),
+		),
+	)
+
+	if err := mutator(logger, baseBench); err != nil {
+		return nil, err
+	}
+
+	return benches, nil
+}
+
+func battery03GenerateBenchmarks(logger zerolog.Logger) ([]Benchmark, error) {
+	baseBench := Benchmark{
+		FlinkMaster: "[local]",
+		NumEvents:   IntPtr(MAX_EVENTS),
+		Parallelism: 2,
+	}
+
+	var benches []Benchmark
+
+	queries := AllQueries
+
+	for _, query := range queries {
+		baseBench.Query = query
+
+		coders := []string{"HAND", "AVRO", "JAVA"}
+		if query == LocalItemSuggestionQuery {
+			coders = []string{"HAND", "AVRO"}
+		}
+
+		mutator := VaryCoderStrategy(coders)(
+			SwapFasterCopy(
+				RepeatRuns(10)(
+					TimerMutator(
+						ArrayBench(&benches),
+					),
+				),
+			),
+		)
+
+		if err := mutator(logger, baseBench); err != nil {
+			return nil, err
+		}
+	}
+
+	return benches, nil
+}
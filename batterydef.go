@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/rs/zerolog"
+)
+
+// A BatteryDef is a declarative description of a battery. It consists of a base benchmark
+// and an ordered list of stages, which are turned into the same middleware chain that
+// the battery functions build by hand. The first stage is the outermost one.
+type BatteryDef struct {
+	Base   Benchmark  `json:"base"`
+	Stages []StageDef `json:"stages"`
+}
+
+// A StageDef is one middleware in a battery definition. The params are specific to the type.
+type StageDef struct {
+	Type   string          `json:"type"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+type rangeParams struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+	Step  int `json:"step"`
+}
+
+type intListParams struct {
+	Values []int `json:"values"`
+}
+
+type stringListParams struct {
+	Values []string `json:"values"`
+}
+
+type extraArgParams struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values"`
+}
+
+type floatRangeParams struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Step  float64 `json:"step"`
+}
+
+type geometricParams struct {
+	Start  float64 `json:"start"`
+	End    float64 `json:"end"`
+	Factor float64 `json:"factor"`
+}
+
+type boundsParams struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+type perDecadeParams struct {
+	Start  float64 `json:"start"`
+	End    float64 `json:"end"`
+	Points int     `json:"points"`
+}
+
+// varyParams selects the field and exactly one way of giving the values it is swept over.
+type varyParams struct {
+	Field       string            `json:"field"`
+	Values      []interface{}     `json:"values"`
+	Linear      *floatRangeParams `json:"linear"`
+	Geometric   *geometricParams  `json:"geometric"`
+	PowersOfTwo *boundsParams     `json:"powers_of_two"`
+	PerDecade   *perDecadeParams  `json:"per_decade"`
+}
+
+// values returns the values described by the params.
+func (p varyParams) values() ([]interface{}, error) {
+	var vals []interface{}
+	given := 0
+	if p.Values != nil {
+		vals = p.Values
+		given++
+	}
+	if p.Linear != nil {
+		vals = LinearRange(p.Linear.Start, p.Linear.End, p.Linear.Step)
+		given++
+	}
+	if p.Geometric != nil {
+		vals = GeometricRange(p.Geometric.Start, p.Geometric.End, p.Geometric.Factor)
+		given++
+	}
+	if p.PowersOfTwo != nil {
+		vals = PowersOfTwo(p.PowersOfTwo.Start, p.PowersOfTwo.End)
+		given++
+	}
+	if p.PerDecade != nil {
+		vals = PointsPerDecade(p.PerDecade.Start, p.PerDecade.End, p.PerDecade.Points)
+		given++
+	}
+	if given != 1 {
+		return nil, fmt.Errorf("exactly one of values, linear, geometric, powers_of_two and per_decade must be given")
+	}
+	return vals, nil
+}
+
+type repeatParams struct {
+	Times int `json:"times"`
+}
+
+// stageBuilders maps the stage types to functions building the middleware from the params.
+var stageBuilders = map[string]func(params json.RawMessage) (Middleware, error){
+	"VaryNumberOfGenerators": rangeStage(VaryNumberOfGenerators),
+	"VaryAvgPersonSize":      rangeStage(VaryAvgPersonSize),
+	"VaryAvgAuctionSize":     rangeStage(VaryAvgAuctionSize),
+	"VaryAvgBidSize":         rangeStage(VaryAvgBidSize),
+	"VaryParallelism":        rangeStage(VaryParallelism),
+	"UseParallelism": func(params json.RawMessage) (Middleware, error) {
+		var p intListParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return UseParallelism(p.Values), nil
+	},
+	"VaryCoderStrategy": func(params json.RawMessage) (Middleware, error) {
+		var p stringListParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return VaryCoderStrategy(p.Values), nil
+	},
+	"VaryQuery": func(params json.RawMessage) (Middleware, error) {
+		var p stringListParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return VaryQuery(p.Values), nil
+	},
+	"VaryExtraArg": func(params json.RawMessage) (Middleware, error) {
+		var p extraArgParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Name == "" {
+			return nil, fmt.Errorf("name must be given")
+		}
+		return VaryExtraArg(p.Name, p.Values), nil
+	},
+	"Vary": func(params json.RawMessage) (Middleware, error) {
+		var p varyParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+
+		vals, err := p.values()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := convertFieldValues(p.Field, vals); err != nil {
+			return nil, err
+		}
+		return Vary(p.Field, vals), nil
+	},
+	"Design": func(params json.RawMessage) (Middleware, error) {
+		var p Design
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		return p.Middleware(), nil
+	},
+	"RepeatRuns": func(params json.RawMessage) (Middleware, error) {
+		var p repeatParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return RepeatRuns(p.Times), nil
+	},
+	"SwapFasterCopy": noParamsStage(SwapFasterCopy),
+	"TimerMutator":   noParamsStage(TimerMutator),
+}
+
+func rangeStage(f func(start, end, step int) Middleware) func(json.RawMessage) (Middleware, error) {
+	return func(params json.RawMessage) (Middleware, error) {
+		var p rangeParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.Step <= 0 {
+			return nil, fmt.Errorf("step must be positive, got %d", p.Step)
+		}
+		return f(p.Start, p.End, p.Step), nil
+	}
+}
+
+func noParamsStage(mw Middleware) func(json.RawMessage) (Middleware, error) {
+	return func(params json.RawMessage) (Middleware, error) {
+		if len(params) != 0 && string(params) != "null" {
+			return nil, fmt.Errorf("stage takes no params")
+		}
+		return mw, nil
+	}
+}
+
+// decodeParams decodes the params strictly, so that typos in the battery file are caught.
+func decodeParams(params json.RawMessage, dst interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.DisallowUnknownFields()
+	return dec.Decode(dst)
+}
+
+// LoadBatteryDef reads a battery definition from a JSON file.
+func LoadBatteryDef(path string) (*BatteryDef, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var def BatteryDef
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&def); err != nil {
+		return nil, fmt.Errorf("parsing battery file %s: %w", path, err)
+	}
+
+	// Build the chain once, so that errors in the definition are reported at load time.
+	if _, err := def.Mutator(new([]Benchmark)); err != nil {
+		return nil, fmt.Errorf("parsing battery file %s: %w", path, err)
+	}
+
+	return &def, nil
+}
+
+// Mutator builds the middleware chain described by the definition, ending in an ArrayBench
+// which appends to arr.
+func (d *BatteryDef) Mutator(arr *[]Benchmark) (Mutator, error) {
+	mut := ArrayBench(arr)
+	for i := len(d.Stages) - 1; i >= 0; i-- {
+		stage := d.Stages[i]
+		build, ok := stageBuilders[stage.Type]
+		if !ok {
+			return nil, fmt.Errorf("stage %d: unknown type %q", i, stage.Type)
+		}
+		mw, err := build(stage.Params)
+		if err != nil {
+			return nil, fmt.Errorf("stage %d (%s): %w", i, stage.Type, err)
+		}
+		mut = mw(mut)
+	}
+	return mut, nil
+}
+
+// Battery returns a battery which generates the benchmarks described by the definition.
+func (d *BatteryDef) Battery() Battery {
+	return func(logger zerolog.Logger) ([]Benchmark, error) {
+		var benches []Benchmark
+		mutator, err := d.Mutator(&benches)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := mutator(logger, d.Base); err != nil {
+			return nil, err
+		}
+
+		return benches, nil
+	}
+}
@@ -0,0 +1,704 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+var errUsage = errors.New("bad usage")
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] <command> [args]
+
+Commands:
+  series list [-tag tag]                       list all series in the store
+  series show <sid>                            show the metadata of a series
+  series edit [flags] <sid>                    change the title, description or tags of a series
+  series create -battery <name> <sid>          generate a battery and store it as a series
+  series create -file <path> <sid>             generate a series from a battery definition file
+  series create -merge [flags] <sid>           update a series, keeping the results that still apply
+  series preview -battery <name>|-file <path>  show what a battery would run, without running it
+  series run [flags] <sid>                     run all benchmarks in a series that have not run yet
+  series status <sid>                          show the statuses of a series and why runs failed
+  series export [flags] <sid>                  export the runs of a series as JSON lines
+  series delete <sid>                          delete a series and all of its results
+  runs [-json] <hash>|<Field=value>...         find the runs of a configuration in all series
+  store migrate                                migrate the store to the current schema version
+
+Flags:
+`, filepath.Base(os.Args[0]))
+	flag.PrintDefaults()
+}
+
+// App holds the state shared between the commands of the command line tool.
+type App struct {
+	logger   zerolog.Logger
+	settings Settings
+}
+
+// Run dispatches the given command line arguments to the right command.
+func (a *App) Run(args []string) error {
+	switch args[0] {
+	case "series":
+		return a.runSeries(args[1:])
+	case "runs":
+		return a.runs(args[1:])
+	case "store":
+		return a.runStore(args[1:])
+	default:
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown command %q\n", args[0])
+		usage()
+		return errUsage
+	}
+}
+
+func (a *App) runSeries(args []string) error {
+	cmds := map[string]func([]string) error{
+		"list":    a.seriesList,
+		"show":    a.seriesShow,
+		"edit":    a.seriesEdit,
+		"create":  a.seriesCreate,
+		"preview": a.seriesPreview,
+		"run":     a.seriesRun,
+		"status":  a.seriesStatus,
+		"export":  a.seriesExport,
+		"delete":  a.seriesDelete,
+	}
+
+	if len(args) < 1 {
+		usage()
+		return errUsage
+	}
+	cmd, ok := cmds[args[0]]
+	if !ok {
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown series command %q\n", args[0])
+		usage()
+		return errUsage
+	}
+	return cmd(args[1:])
+}
+
+func (a *App) runStore(args []string) error {
+	if len(args) < 1 || args[0] != "migrate" {
+		if len(args) > 0 {
+			fmt.Fprintf(flag.CommandLine.Output(), "unknown store command %q\n", args[0])
+		}
+		usage()
+		return errUsage
+	}
+	return a.storeMigrate(args[1:])
+}
+
+// storeMigrate brings an existing store up to the current schema version, which NewStore
+// does when opening it.
+func (a *App) storeMigrate(args []string) error {
+	fs := flag.NewFlagSet("store migrate", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if ok, err := FileExists(a.settings.DBPath); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("there is no store at %s", a.settings.DBPath)
+	}
+
+	store, err := a.openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	a.logger.Info().Str("store", a.settings.DBPath).Int("version", SchemaVersion).Msg("Store is up to date")
+	return nil
+}
+
+func (a *App) openStore() (*Store, error) {
+	return NewStore(a.logger, a.settings.DBPath)
+}
+
+// openStoreReadOnly opens the store for the commands that only read it, so that several of
+// them can use it at the same time. Stores that do not exist yet are created, but stores
+// that need migrating are left alone, as reading them must not upgrade them.
+func (a *App) openStoreReadOnly() (*Store, error) {
+	if ok, err := FileExists(a.settings.DBPath); err != nil {
+		return nil, err
+	} else if !ok {
+		return a.openStore()
+	}
+	store, err := NewStoreReadOnly(a.logger, a.settings.DBPath)
+	if errors.Is(err, ErrorSchemaOutdated) {
+		return nil, fmt.Errorf("%w: run %s store migrate to migrate it", err, filepath.Base(os.Args[0]))
+	}
+	return store, err
+}
+
+// parseSeriesArgs parses the flags of a series command which takes a single series id.
+func parseSeriesArgs(fs *flag.FlagSet, args []string) (string, error) {
+	if err := fs.Parse(args); err != nil {
+		return "", errUsage
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintf(fs.Output(), "expected exactly one series id\n")
+		fs.Usage()
+		return "", errUsage
+	}
+	return fs.Arg(0), nil
+}
+
+// stringList is a flag that can be given several times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+func (a *App) seriesList(args []string) error {
+	fs := flag.NewFlagSet("series list", flag.ContinueOnError)
+	tag := fs.String("tag", "", "only list the series with this tag")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	store, err := a.openStoreReadOnly()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ids, err := store.ListSeries()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "SERIES\tBENCHMARKS\tCREATED\tTAGS\tTITLE")
+	for _, id := range ids {
+		meta, err := store.GetSeriesMeta(id)
+		if err != nil {
+			return err
+		}
+		if *tag != "" && !meta.HasTag(*tag) {
+			continue
+		}
+		statuses, err := store.GetSeriesStatuses(id)
+		if err != nil {
+			return err
+		}
+
+		created := "-"
+		if !meta.CreatedAt.IsZero() {
+			created = meta.CreatedAt.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", id, len(statuses), created, strings.Join(meta.Tags, ","), meta.Title)
+	}
+	return tw.Flush()
+}
+
+func (a *App) seriesShow(args []string) error {
+	fs := flag.NewFlagSet("series show", flag.ContinueOnError)
+	sid, err := parseSeriesArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	store, err := a.openStoreReadOnly()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	meta, err := store.GetSeriesMeta(sid)
+	if err != nil {
+		return err
+	}
+	statuses, err := store.GetSeriesStatuses(sid)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Series:\t%s\n", sid)
+	fmt.Fprintf(tw, "Title:\t%s\n", meta.Title)
+	fmt.Fprintf(tw, "Tags:\t%s\n", strings.Join(meta.Tags, ", "))
+	fmt.Fprintf(tw, "Benchmarks:\t%d\n", len(statuses))
+	if !meta.CreatedAt.IsZero() {
+		fmt.Fprintf(tw, "Created:\t%s\n", meta.CreatedAt.Local().Format(time.RFC3339))
+	}
+	if !meta.UpdatedAt.IsZero() {
+		fmt.Fprintf(tw, "Updated:\t%s\n", meta.UpdatedAt.Local().Format(time.RFC3339))
+	}
+	fmt.Fprintf(tw, "Generator:\t%s\n", meta.Generator)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if meta.Description != "" {
+		fmt.Printf("\n%s\n", meta.Description)
+	}
+	if meta.Definition != nil {
+		var def bytes.Buffer
+		if err := json.Indent(&def, meta.Definition, "", "  "); err != nil {
+			return err
+		}
+		fmt.Printf("\nBattery definition:\n%s\n", def.String())
+	}
+	return nil
+}
+
+func (a *App) seriesEdit(args []string) error {
+	fs := flag.NewFlagSet("series edit", flag.ContinueOnError)
+	title := fs.String("title", "", "new title of the series")
+	description := fs.String("description", "", "new description of the series")
+	var tags, untags stringList
+	fs.Var(&tags, "tag", "tag to add to the series, can be given several times")
+	fs.Var(&untags, "untag", "tag to remove from the series, can be given several times")
+	sid, err := parseSeriesArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	// Only the flags that were given are changed, so that they can be set to "".
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	store, err := a.openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	return store.UpdateSeriesMeta(sid, func(meta *SeriesMeta) {
+		if given["title"] {
+			meta.Title = *title
+		}
+		if given["description"] {
+			meta.Description = *description
+		}
+		meta.RemoveTags(untags...)
+		meta.AddTags(tags...)
+	})
+}
+
+func (a *App) seriesCreate(args []string) error {
+	fs := flag.NewFlagSet("series create", flag.ContinueOnError)
+	battery := fs.String("battery", "", "name of the battery to generate ("+strings.Join(batteryNames(), ", ")+")")
+	batteryFile := fs.String("file", "", "battery definition file to generate the series from")
+	force := fs.Bool("force", false, "overwrite the series if it already exists")
+	merge := fs.Bool("merge", false, "merge into an existing series, keeping the results of benchmarks that are still in it")
+	reuse := fs.Bool("reuse", false, "reuse the successful runs of the same configurations in other series")
+	validate := fs.String("validate", ValidateReject, "what to do with benchmarks with known incompatibilities: reject, drop or warn")
+	schedule := fs.String("schedule", "", "order to run the benchmarks in: "+strings.Join(Schedules, ", ")+" (default none, or the saved one when merging)")
+	seed := fs.Int64("seed", 0, "seed for randomized schedules (default based on the current time, or the saved one when merging)")
+	title := fs.String("title", "", "title of the series")
+	description := fs.String("description", "", "description of what the series is for")
+	var tags stringList
+	fs.Var(&tags, "tag", "tag of the series, can be given several times")
+	sid, err := parseSeriesArgs(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := ValidateSeriesID(sid); err != nil {
+		return err
+	}
+
+	genBench, name, err := selectBattery(*battery, *batteryFile)
+	if err != nil {
+		return err
+	}
+	meta := SeriesMeta{
+		Title:       *title,
+		Description: *description,
+		Tags:        tags,
+		Generator:   name,
+	}
+	if *batteryFile != "" {
+		data, err := ioutil.ReadFile(*batteryFile)
+		if err != nil {
+			return err
+		}
+		var def bytes.Buffer
+		if err := json.Compact(&def, data); err != nil {
+			return err
+		}
+		meta.Definition = def.Bytes()
+	}
+
+	store, err := a.openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if *force && *merge {
+		return fmt.Errorf("only one of -force and -merge can be given")
+	}
+	if ok, err := store.HasSeries(sid); err != nil {
+		return err
+	} else if ok && !*force && !*merge {
+		return fmt.Errorf("series %q already exists, use -force to overwrite it or -merge to merge into it", sid)
+	}
+
+	logger := a.logger.With().Str("series_key", sid).Str("battery", name).Logger()
+	return storeBattery(logger, store, sid, genBench, CreateOptions{
+		Validate: *validate,
+		Schedule: *schedule,
+		Seed:     *seed,
+		Merge:    *merge,
+		Reuse:    *reuse,
+		Meta:     meta,
+	})
+}
+
+// selectBattery returns either the named battery or the one defined in the file, together
+// with a name for it.
+func selectBattery(battery, batteryFile string) (Battery, string, error) {
+	switch {
+	case battery != "" && batteryFile != "":
+		return nil, "", fmt.Errorf("only one of -battery and -file can be given")
+	case batteryFile != "":
+		def, err := LoadBatteryDef(batteryFile)
+		if err != nil {
+			return nil, "", err
+		}
+		return def.Battery(), batteryFile, nil
+	default:
+		genBench, ok := Batteries[battery]
+		if !ok {
+			return nil, "", fmt.Errorf("unknown battery %q", battery)
+		}
+		return genBench, battery, nil
+	}
+}
+
+func (a *App) seriesPreview(args []string) error {
+	fs := flag.NewFlagSet("series preview", flag.ContinueOnError)
+	battery := fs.String("battery", "", "name of the battery to preview ("+strings.Join(batteryNames(), ", ")+")")
+	batteryFile := fs.String("file", "", "battery definition file to preview")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	genBench, name, err := selectBattery(*battery, *batteryFile)
+	if err != nil {
+		return err
+	}
+	executor, err := a.settings.UncheckedExecutor()
+	if err != nil {
+		return err
+	}
+
+	// Only read the store if it exists, a preview must never create or change it.
+	var history map[string][]float64
+	if ok, err := FileExists(a.settings.DBPath); err != nil {
+		return err
+	} else if ok {
+		store, err := NewStoreReadOnly(a.logger, a.settings.DBPath)
+		if errors.Is(err, ErrorSchemaOutdated) || errors.Is(err, ErrorStoreBusy) {
+			a.logger.Warn().Err(err).Msg("Previewing without the runtimes of earlier runs")
+		} else if err != nil {
+			return err
+		} else {
+			history, err = store.RuntimeHistory()
+			store.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	logger := a.logger.With().Str("battery", name).Logger()
+	benches, err := genBench(logger)
+	if err != nil {
+		return err
+	}
+
+	preview, err := NewPreview(benches, executor, a.settings.SkipRules, history)
+	if err != nil {
+		return err
+	}
+	return preview.Print(os.Stdout)
+}
+
+func (a *App) seriesRun(args []string) error {
+	fs := flag.NewFlagSet("series run", flag.ContinueOnError)
+	timeout := fs.Duration("timeout", 0, "kill a benchmark if it runs for longer than this, 0 means no limit")
+	forceSkipped := fs.Bool("force-skipped", false, "run benchmarks even if they match a skip rule")
+	sid, err := parseSeriesArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	executor, err := a.settings.NewExecutor()
+	if err != nil {
+		return err
+	}
+
+	store, err := a.openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	ctx, cancel := signalContext(a.logger)
+	defer cancel()
+
+	err = store.RunSeries(ctx, sid, RunOptions{
+		Executor:     executor,
+		Timeout:      *timeout,
+		SkipRules:    a.settings.SkipRules,
+		ForceSkipped: *forceSkipped,
+		RunsDir:      filepath.Join(a.settings.ResultsDir, "runs"),
+		Env:          a.settings.Environment(ctx, a.logger),
+	})
+	if err == context.Canceled {
+		a.logger.Warn().Str("series_key", sid).Msg("Run was interrupted")
+		return nil
+	}
+	return err
+}
+
+func (a *App) seriesStatus(args []string) error {
+	fs := flag.NewFlagSet("series status", flag.ContinueOnError)
+	sid, err := parseSeriesArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	store, err := a.openStoreReadOnly()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	statuses, err := store.GetSeriesStatuses(sid)
+	if err != nil {
+		return err
+	}
+
+	counts := make(map[string]int)
+	for _, status := range statuses {
+		counts[status]++
+	}
+	var keys []string
+	for status := range counts {
+		keys = append(keys, status)
+	}
+	sort.Strings(keys)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "STATUS\tCOUNT\n")
+	for _, status := range keys {
+		fmt.Fprintf(tw, "%s\t%d\n", status, counts[status])
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\n", len(statuses))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if counts[StatusErr]+counts[StatusTimeout]+counts[StatusPartial] == 0 {
+		return nil
+	}
+	runs, err := store.GetSeriesResults(sid)
+	if err != nil {
+		return err
+	}
+	fmt.Println()
+	fmt.Fprintf(tw, "FAILURE\tCOUNT\tCONFIGURATION\tEXCEPTION\n")
+	for _, g := range SummarizeFailures(runs) {
+		exception := g.CommonException()
+		if exception == "" {
+			exception = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", g.Category, g.Count, g.Config, exception)
+	}
+	return tw.Flush()
+}
+
+func (a *App) seriesExport(args []string) error {
+	fs := flag.NewFlagSet("series export", flag.ContinueOnError)
+	out := fs.String("o", "", "file to write to, - for stdout (default <results>/<sid>.json)")
+	excludePartial := fs.Bool("exclude-partial", false, "leave out runs with partial results recovered from their output")
+	sid, err := parseSeriesArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	store, err := a.openStoreReadOnly()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		if *out == "" {
+			*out = filepath.Join(a.settings.ResultsDir, sid+".json")
+		}
+		fr, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer fr.Close()
+		w = fr
+	}
+
+	return exportSeries(store, sid, w, *excludePartial)
+}
+
+func (a *App) seriesDelete(args []string) error {
+	fs := flag.NewFlagSet("series delete", flag.ContinueOnError)
+	sid, err := parseSeriesArgs(fs, args)
+	if err != nil {
+		return err
+	}
+
+	store, err := a.openStore()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := store.DeleteSeries(sid); err != nil {
+		return err
+	}
+	a.logger.Info().Str("series_key", sid).Msg("Deleted series")
+	return nil
+}
+
+func batteryNames() []string {
+	var names []string
+	for name := range Batteries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// signalContext returns a context which is cancelled on the first SIGINT or SIGTERM.
+// After that the signals are no longer caught, so a second one kills the process.
+func signalContext(logger zerolog.Logger) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigs:
+			logger.Warn().Str("signal", sig.String()).Msg("Interrupting, send again to exit immediately")
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(sigs)
+	}()
+
+	return ctx, cancel
+}
+
+func (a *App) runs(args []string) error {
+	fs := flag.NewFlagSet("runs", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "write the runs as JSON lines instead of a table")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() == 0 {
+		fmt.Fprintf(fs.Output(), "expected a hash or field filters\n")
+		fs.Usage()
+		return errUsage
+	}
+
+	var prefix string
+	match := make(map[string]interface{})
+	for _, arg := range fs.Args() {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) == 1 {
+			prefix = arg
+			continue
+		}
+		if !isBenchmarkField(parts[0]) {
+			return fmt.Errorf("unknown benchmark field %q", parts[0])
+		}
+		match[parts[0]] = parseFieldValue(parts[1])
+	}
+
+	store, err := a.openStoreReadOnly()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	hashes, err := store.MatchConfigs(match)
+	if err != nil {
+		return err
+	}
+
+	var runs []IndexedRun
+	for _, hash := range hashes {
+		if !strings.HasPrefix(hash, prefix) {
+			continue
+		}
+		found, err := store.FindRuns(hash)
+		if err != nil {
+			return err
+		}
+		runs = append(runs, found...)
+	}
+
+	if *asJSON {
+		jec := json.NewEncoder(os.Stdout)
+		for _, run := range runs {
+			if err := jec.Encode(run); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "HASH\tSERIES\tBID\tSTATUS\tRUNTIME")
+	for _, run := range runs {
+		runtime := "-"
+		if run.Result != nil {
+			runtime = secondsToDuration(run.Result.Perf.RuntimeSec).String()
+		}
+		status := run.Status
+		if run.Archived {
+			status += " (archived)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\n", run.Hash[:12], run.Series, run.Bid, status, runtime)
+	}
+	return tw.Flush()
+}
+
+// parseFieldValue parses the value of a Field=value filter as JSON, so that numbers and
+// booleans match, and falls back to using it as a string.
+func parseFieldValue(s string) interface{} {
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return s
+	}
+	return v
+}
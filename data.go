@@ -3,6 +3,10 @@ package main
 type Result struct {
 	JSResult
 	Extra Extra `json:"extra"`
+
+	// Recovered is set for partial results recovered from the console output of a run that
+	// failed or wrote no results file. It is the status the run would have had otherwise.
+	Recovered string `json:"recovered,omitempty"`
 }
 
 type Extra struct {
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// DesignFull is every combination of the levels of all factors.
+	DesignFull = "full"
+	// DesignFractional is a 2^(k-p) fractional factorial. All factors have two levels, the
+	// first k-p are combined fully and the other p are given by the generators.
+	DesignFractional = "fractional"
+	// DesignLatinHypercube draws Samples points, with each factor's range split into Samples
+	// strata and every stratum used exactly once.
+	DesignLatinHypercube = "lhs"
+	// DesignOneFactorAtATime is the base, plus the base with one factor changed to each of its levels.
+	DesignOneFactorAtATime = "ofat"
+)
+
+// A Factor is a benchmark field and the levels it takes in a design.
+type Factor struct {
+	// Field is the Go name of the benchmark field, like in Vary.
+	Field string `json:"field"`
+	// Levels are the values of the factor. Fractional designs use the first as the low
+	// and the second as the high level.
+	Levels []interface{} `json:"levels,omitempty"`
+	// Min and Max give the range of a numeric factor in a Latin hypercube, used instead of the levels.
+	Min *float64 `json:"min,omitempty"`
+	Max *float64 `json:"max,omitempty"`
+}
+
+// A Design generates a set of benchmarks from a base benchmark by varying several factors
+// together, as an alternative to nesting Vary middlewares.
+type Design struct {
+	Kind    string   `json:"kind"`
+	Factors []Factor `json:"factors"`
+
+	// Generators define the extra factors of a fractional design, like "D=ABC", where the
+	// letters are the factors in order. The first len(Factors)-len(Generators) factors are
+	// the base factors.
+	Generators []string `json:"generators,omitempty"`
+
+	// Samples and Seed are used by Latin hypercube designs.
+	Samples int   `json:"samples,omitempty"`
+	Seed    int64 `json:"seed,omitempty"`
+}
+
+// Middleware runs the mutator once for each point of the design, using the incoming
+// benchmark as the base. This lets a design be one stage of a battery.
+func (d Design) Middleware() Middleware {
+	return func(mut Mutator) Mutator {
+		return func(logger zerolog.Logger, b Benchmark) error {
+			points, err := d.Points(b)
+			if err != nil {
+				return err
+			}
+			for i, p := range points {
+				logger := logger.With().Int("point", i).Logger()
+				if err := mut(logger, p); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// Battery returns a battery which runs each point of the design once.
+func (d Design) Battery(base Benchmark) Battery {
+	return func(logger zerolog.Logger) ([]Benchmark, error) {
+		var benches []Benchmark
+		if err := d.Middleware()(ArrayBench(&benches))(logger, base); err != nil {
+			return nil, err
+		}
+		return benches, nil
+	}
+}
+
+// Validate checks the design without generating it.
+func (d Design) Validate() error {
+	_, err := d.Points(Benchmark{})
+	return err
+}
+
+// Points returns the benchmarks of the design.
+func (d Design) Points(base Benchmark) ([]Benchmark, error) {
+	if len(d.Factors) == 0 {
+		return nil, fmt.Errorf("design has no factors")
+	}
+	for _, f := range d.Factors {
+		if _, err := benchmarkField(f.Field); err != nil {
+			return nil, err
+		}
+	}
+
+	var levels [][]interface{}
+	switch d.Kind {
+	case DesignFull:
+		levels = fullFactorial(d.Factors)
+	case DesignFractional:
+		var err error
+		if levels, err = d.fractional(); err != nil {
+			return nil, err
+		}
+	case DesignLatinHypercube:
+		var err error
+		if levels, err = d.latinHypercube(); err != nil {
+			return nil, err
+		}
+	case DesignOneFactorAtATime:
+		return d.oneFactorAtATime(base)
+	default:
+		return nil, fmt.Errorf("unknown design kind %q", d.Kind)
+	}
+
+	points := make([]Benchmark, 0, len(levels))
+	for _, row := range levels {
+		p := base
+		for fi, f := range d.Factors {
+			if err := setFieldValue(&p, f.Field, row[fi]); err != nil {
+				return nil, err
+			}
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
+
+// setFieldValue converts the value to the type of the field and sets it.
+func setFieldValue(b *Benchmark, field string, val interface{}) error {
+	f, err := benchmarkField(field)
+	if err != nil {
+		return err
+	}
+	t := f.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	v, err := convertValue(val, t)
+	if err != nil {
+		return fmt.Errorf("field %s: %w", field, err)
+	}
+	setField(b, field, v)
+	return nil
+}
+
+// fullFactorial returns every combination of levels, with the first factor varying slowest.
+func fullFactorial(factors []Factor) [][]interface{} {
+	rows := [][]interface{}{nil}
+	for _, f := range factors {
+		var next [][]interface{}
+		for _, row := range rows {
+			for _, l := range f.Levels {
+				next = append(next, append(append([]interface{}(nil), row...), l))
+			}
+		}
+		rows = next
+	}
+	return rows
+}
+
+// fractional returns the runs of the 2^(k-p) design in standard order.
+func (d Design) fractional() ([][]interface{}, error) {
+	k := len(d.Factors)
+	p := len(d.Generators)
+	if p >= k {
+		return nil, fmt.Errorf("a fractional design needs more factors than generators")
+	}
+	if k > 26 {
+		return nil, fmt.Errorf("a fractional design can have at most 26 factors")
+	}
+	for _, f := range d.Factors {
+		if len(f.Levels) != 2 {
+			return nil, fmt.Errorf("factor %s of a fractional design must have exactly two levels", f.Field)
+		}
+	}
+
+	// gens[i] lists the base factors whose product gives factor k-p+i.
+	base := k - p
+	gens := make([][]int, p)
+	seen := make(map[int]bool)
+	for _, g := range d.Generators {
+		parts := strings.SplitN(strings.ReplaceAll(g, " ", ""), "=", 2)
+		if len(parts) != 2 || len(parts[0]) != 1 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("generator %q is not of the form D=ABC", g)
+		}
+		target := int(parts[0][0] - 'A')
+		if target < base || target >= k || seen[target] {
+			return nil, fmt.Errorf("generator %q must define one of the last %d factors, once", g, p)
+		}
+		seen[target] = true
+		for _, c := range parts[1] {
+			f := int(c - 'A')
+			if f < 0 || f >= base {
+				return nil, fmt.Errorf("generator %q can only use the base factors A-%c", g, 'A'+base-1)
+			}
+			gens[target-base] = append(gens[target-base], f)
+		}
+	}
+
+	var rows [][]interface{}
+	for run := 0; run < 1<<uint(base); run++ {
+		signs := make([]int, k)
+		// The first factor alternates fastest, as in the standard (Yates) order.
+		for f := 0; f < base; f++ {
+			signs[f] = -1
+			if run&(1<<uint(f)) != 0 {
+				signs[f] = 1
+			}
+		}
+		for i, g := range gens {
+			s := 1
+			for _, f := range g {
+				s *= signs[f]
+			}
+			signs[base+i] = s
+		}
+
+		row := make([]interface{}, k)
+		for f, s := range signs {
+			if s < 0 {
+				row[f] = d.Factors[f].Levels[0]
+			} else {
+				row[f] = d.Factors[f].Levels[1]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// latinHypercube draws the samples of the design.
+func (d Design) latinHypercube() ([][]interface{}, error) {
+	n := d.Samples
+	if n <= 0 {
+		return nil, fmt.Errorf("a latin hypercube needs a positive number of samples")
+	}
+	rnd := rand.New(rand.NewSource(d.Seed))
+
+	rows := make([][]interface{}, n)
+	for i := range rows {
+		rows[i] = make([]interface{}, len(d.Factors))
+	}
+	for fi, f := range d.Factors {
+		perm := rnd.Perm(n)
+		switch {
+		case f.Min != nil && f.Max != nil:
+			width := (*f.Max - *f.Min) / float64(n)
+			for i, stratum := range perm {
+				rows[i][fi] = *f.Min + (float64(stratum)+rnd.Float64())*width
+			}
+		case len(f.Levels) > 0:
+			// Discrete factors use each level equally often, in random order.
+			for i, stratum := range perm {
+				rows[i][fi] = f.Levels[stratum*len(f.Levels)/n]
+			}
+		default:
+			return nil, fmt.Errorf("factor %s needs either min and max or levels", f.Field)
+		}
+	}
+	return rows, nil
+}
+
+// oneFactorAtATime returns the base, followed by the base with each factor set to each of
+// its levels that differ from the base.
+func (d Design) oneFactorAtATime(base Benchmark) ([]Benchmark, error) {
+	points := []Benchmark{base}
+	baseKey, err := configKey(base)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range d.Factors {
+		for _, l := range f.Levels {
+			p := base
+			if err := setFieldValue(&p, f.Field, l); err != nil {
+				return nil, err
+			}
+			if key, err := configKey(p); err != nil {
+				return nil, err
+			} else if key == baseKey {
+				continue
+			}
+			points = append(points, p)
+		}
+	}
+	return points, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// ArtifactJavascript is the name of the javascript results file artifact.
+	ArtifactJavascript = "javascript"
+
+	DefaultNexmarkRunner    = ":runners:flink:1.10"
+	DefaultNexmarkMainClass = "org.apache.beam.sdk.nexmark.Main"
+)
+
+// An Execution is the outcome of one Nexmark invocation.
+type Execution struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+
+	// Artifacts maps the name of each file the invocation produced to its path.
+	Artifacts map[string]string
+}
+
+// An Executor launches Nexmark with the given arguments. The error is non nil if the
+// invocation couldn't be started or didn't exit successfully, in which case the execution
+// still holds whatever output was produced. If the context is done before Nexmark exits,
+// the invocation is killed and the error wraps the error of the context.
+type Executor interface {
+	Execute(ctx context.Context, logger zerolog.Logger, args []string) (*Execution, error)
+}
+
+// GradleExecutor runs Nexmark through the :sdks:java:testing:nexmark:run gradle task.
+type GradleExecutor struct {
+	GradlePath string
+	BeamPath   string
+	// Runner is the gradle project of the runner, like :runners:flink:1.10.
+	Runner string
+}
+
+// A CommandBuilder is an executor that can tell what command it runs for the arguments.
+type CommandBuilder interface {
+	Command(nargs []string) []string
+}
+
+// Command returns the gradle command line, starting with the gradle wrapper. The daemon is
+// disabled, as Nexmark would otherwise run as a child of a daemon outside of the process
+// group, and survive being killed when the run times out.
+func (e *GradleExecutor) Command(nargs []string) []string {
+	runner := e.Runner
+	if runner == "" {
+		runner = DefaultNexmarkRunner
+	}
+
+	return []string{
+		e.GradlePath,
+		"--no-daemon",
+		"-p", e.BeamPath,
+		"-Pnexmark.runner=" + runner,
+		"-Pnexmark.args=" + strings.Join(nargs, "\n"),
+		":sdks:java:testing:nexmark:run",
+	}
+}
+
+func (e *GradleExecutor) Execute(ctx context.Context, logger zerolog.Logger, nargs []string) (*Execution, error) {
+	cmd := e.Command(nargs)
+	return runCommand(ctx, logger, exec.Command(cmd[0], cmd[1:]...), nargs)
+}
+
+// JavaExecutor runs Nexmark directly from a shaded jar, skipping the startup cost of gradle.
+// The jar must contain the runner as well as Nexmark itself.
+type JavaExecutor struct {
+	JavaPath   string
+	NexmarkJar string
+	MainClass  string
+}
+
+// Command returns the java command line, starting with the java binary.
+func (e *JavaExecutor) Command(nargs []string) []string {
+	javaPath := e.JavaPath
+	if javaPath == "" {
+		javaPath = "java"
+	}
+	mainClass := e.MainClass
+	if mainClass == "" {
+		mainClass = DefaultNexmarkMainClass
+	}
+
+	return append([]string{javaPath, "-cp", e.NexmarkJar, mainClass}, nargs...)
+}
+
+func (e *JavaExecutor) Execute(ctx context.Context, logger zerolog.Logger, nargs []string) (*Execution, error) {
+	cmd := e.Command(nargs)
+	return runCommand(ctx, logger, exec.Command(cmd[0], cmd[1:]...), nargs)
+}
+
+// runCommand runs the command to completion, or until the context is done. In the latter
+// case the whole process tree is killed, as gradle and the JVM spawn children of their own.
+func runCommand(ctx context.Context, logger zerolog.Logger, c *exec.Cmd, nargs []string) (*Execution, error) {
+	var stdout, stderr bytes.Buffer
+	c.Stderr = &stderr
+	c.Stdout = &stdout
+
+	if false {
+		c.Stderr = io.MultiWriter(os.Stderr, &stderr)
+		c.Stdout = io.MultiWriter(os.Stdout, &stdout)
+	}
+	setProcessGroup(c)
+
+	logger.Debug().Str("cmd", c.Path).Strs("args", c.Args[1:]).Msg("Executing nexmark")
+	err := c.Start()
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			select {
+			case <-ctx.Done():
+				logger.Warn().Err(ctx.Err()).Msg("Killing nexmark")
+				if err := killProcessGroup(c); err != nil {
+					logger.Error().Err(err).Msg("Couldn't kill nexmark")
+				}
+			case <-done:
+			}
+		}()
+		err = c.Wait()
+		close(done)
+
+		if ctx.Err() != nil {
+			err = fmt.Errorf("nexmark was killed: %w", ctx.Err())
+		}
+	}
+
+	ex := &Execution{
+		Stdout:    stdout.Bytes(),
+		Stderr:    stderr.Bytes(),
+		ExitCode:  c.ProcessState.ExitCode(),
+		Artifacts: collectArtifacts(nargs),
+	}
+	return ex, err
+}
+
+// collectArtifacts finds the files Nexmark was told to write and that exist.
+func collectArtifacts(nargs []string) map[string]string {
+	artifacts := make(map[string]string)
+	if fname := nexmarkArg(nargs, "javascriptFilename"); fname != "" {
+		if ok, _ := FileExists(fname); ok {
+			artifacts[ArtifactJavascript] = fname
+		}
+	}
+	return artifacts
+}
+
+// nexmarkArg returns the value of the last --name=value argument, or "" if there is none.
+func nexmarkArg(nargs []string, name string) string {
+	prefix := "--" + name + "="
+	var val string
+	for _, arg := range nargs {
+		if strings.HasPrefix(arg, prefix) {
+			val = arg[len(prefix):]
+		}
+	}
+	return val
+}
+
+// FakeExecutor doesn't launch anything, but pretends to have run Nexmark. It is meant for
+// testing the rest of the tool without a Beam checkout.
+type FakeExecutor struct {
+	Stdout   []byte
+	Stderr   []byte
+	ExitCode int
+
+	// Perf is written to the javascript file, together with a config built from the arguments.
+	Perf Perf
+
+	// Delay is how long the fake invocation takes.
+	Delay time.Duration
+}
+
+func (e *FakeExecutor) Execute(ctx context.Context, logger zerolog.Logger, nargs []string) (*Execution, error) {
+	ex := &Execution{
+		Stdout:    e.Stdout,
+		Stderr:    e.Stderr,
+		ExitCode:  e.ExitCode,
+		Artifacts: make(map[string]string),
+	}
+
+	select {
+	case <-time.After(e.Delay):
+	case <-ctx.Done():
+		ex.ExitCode = -1
+		return ex, fmt.Errorf("nexmark was killed: %w", ctx.Err())
+	}
+
+	if e.ExitCode != 0 {
+		return ex, fmt.Errorf("fake executor exited with %d", e.ExitCode)
+	}
+
+	if fname := nexmarkArg(nargs, "javascriptFilename"); fname != "" {
+		if err := e.writeJavascript(fname, nargs); err != nil {
+			return ex, err
+		}
+		ex.Artifacts[ArtifactJavascript] = fname
+	}
+	return ex, nil
+}
+
+// writeJavascript writes a results file in the same format as Nexmark does.
+func (e *FakeExecutor) writeJavascript(fname string, nargs []string) error {
+	config, err := json.Marshal(Config{
+		Query:         nexmarkArg(nargs, "query"),
+		CoderStrategy: nexmarkArg(nargs, "coderStrategy"),
+	})
+	if err != nil {
+		return err
+	}
+	perf, err := json.Marshal(e.Perf)
+	if err != nil {
+		return err
+	}
+
+	js := fmt.Sprintf("var all = [\n  {\n    config: %s\n    ,perf: %s\n  },\n];\n", config, perf)
+	return ioutil.WriteFile(fname, []byte(js), 0644)
+}
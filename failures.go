@@ -0,0 +1,191 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Failure categories, from the most to the least specific.
+const (
+	FailureTimeout       = "timeout"
+	FailureOutOfMemory   = "out-of-memory"
+	FailureCoder         = "coder"
+	FailureFlinkJob      = "flink-job"
+	FailureJavaException = "java-exception"
+	FailureGradle        = "gradle"
+	FailureUnknown       = "unknown"
+)
+
+// maxStackTraceLines is how much of the stack trace of the root exception is kept.
+const maxStackTraceLines = 30
+
+// A Failure says why a run failed, as far as can be told from its output.
+type Failure struct {
+	Category string
+
+	// Exception is the class of the root exception, like java.lang.OutOfMemoryError, and
+	// Message its message.
+	Exception string `json:",omitempty"`
+	Message   string `json:",omitempty"`
+	// StackTrace is the start of the stack trace of the root exception.
+	StackTrace string `json:",omitempty"`
+
+	// Task is the gradle task that failed.
+	Task string `json:",omitempty"`
+}
+
+var (
+	exceptionHeader = regexp.MustCompile(`^\s*(?:> )?(?:Exception in thread "[^"]*" |(Caused by: ))?([a-zA-Z_$][\w$]*(?:\.[\w$]+)*\.[\w$]*(?:Exception|Error|Throwable))(?::\s*(.*))?$`)
+	stackFrame      = regexp.MustCompile(`^\s+(at |\.\.\. \d+ more)`)
+	gradleTask      = regexp.MustCompile(`(?:Execution failed for task '([^']+)'|(?:> )?Task (\S+) FAILED)`)
+
+	// The markers are matched against the exceptions of the last stack trace and the message
+	// of its root cause, never the whole output, which has plenty of Flink logging in it.
+	outOfMemoryMarkers = []string{"OutOfMemoryError", "GC overhead limit exceeded", "Java heap space"}
+	coderMarkers       = []string{"CoderException", "SerializationException", "KryoException", "NotSerializableException", "StreamCorruptedException", "InvalidClassException"}
+	flinkJobMarkers    = []string{"JobExecutionException", "ProgramInvocationException", "JobCancellationException"}
+)
+
+// ClassifyFailure finds out why a run with the status failed from its output.
+func ClassifyFailure(status string, stdout, stderr []byte) Failure {
+	// gradle prints the exception of the failure on stderr, the JVM of Nexmark on stdout.
+	output := string(stdout) + "\n" + string(stderr)
+
+	var f Failure
+	chain, message, trace := lastStackTrace(output)
+	if len(chain) > 0 {
+		f.Exception, f.Message, f.StackTrace = chain[len(chain)-1], message, trace
+	}
+	if m := gradleTask.FindStringSubmatch(output); m != nil {
+		f.Task = m[1] + m[2]
+	}
+
+	exceptions := strings.Join(chain, "\n") + "\n" + f.Message
+	switch {
+	case status == StatusTimeout:
+		f.Category = FailureTimeout
+	case containsAny(exceptions, outOfMemoryMarkers):
+		f.Category = FailureOutOfMemory
+	case containsAny(exceptions, coderMarkers):
+		f.Category = FailureCoder
+	case containsAny(exceptions, flinkJobMarkers):
+		f.Category = FailureFlinkJob
+	case f.Exception != "":
+		f.Category = FailureJavaException
+	case f.Task != "":
+		f.Category = FailureGradle
+	default:
+		f.Category = FailureUnknown
+	}
+	return f
+}
+
+func containsAny(s string, subs []string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// lastStackTrace finds the last stack trace in the output. It returns the classes of the
+// exception and its causes, outermost first, and the message and the start of the trace of
+// the root cause. Exceptions are recognised by being followed by stack frames.
+func lastStackTrace(output string) (chain []string, message, trace string) {
+	lines := strings.Split(output, "\n")
+	for i := 0; i < len(lines)-1; i++ {
+		m := exceptionHeader.FindStringSubmatch(strings.TrimRight(lines[i], "\r"))
+		if m == nil || !stackFrame.MatchString(lines[i+1]) {
+			continue
+		}
+		// Causes are printed after what they caused, anything else starts a new trace.
+		if m[1] == "" {
+			chain = nil
+		}
+		chain = append(chain, m[2])
+		message = m[3]
+
+		end := i + 1
+		for end < len(lines) && stackFrame.MatchString(lines[end]) {
+			end++
+		}
+		if end-i > maxStackTraceLines {
+			end = i + maxStackTraceLines
+		}
+		trace = strings.Join(lines[i:end], "\n")
+		i = end - 1
+	}
+	return chain, message, trace
+}
+
+// A FailureGroup is the failed runs of one configuration that failed the same way.
+type FailureGroup struct {
+	Category string
+	// Config describes the configuration by the fields that vary in the series.
+	Config string
+	Count  int
+	// Exceptions counts the root exceptions of the runs.
+	Exceptions map[string]int
+}
+
+// CommonException returns the most common root exception of the group, or "" if there is none.
+func (g FailureGroup) CommonException() string {
+	var common string
+	best := 0
+	for exception, n := range g.Exceptions {
+		if exception == "" {
+			continue
+		}
+		if n > best || n == best && exception < common {
+			common, best = exception, n
+		}
+	}
+	return common
+}
+
+// SummarizeFailures groups the failed runs by category and configuration, the largest
+// groups first. Archived runs are left out.
+func SummarizeFailures(runs []Run) []FailureGroup {
+	var benches []Benchmark
+	for _, run := range runs {
+		if !run.Archived {
+			benches = append(benches, run.Bench)
+		}
+	}
+	fields := varyingFields(benches)
+
+	var groups []FailureGroup
+	index := make(map[[2]string]int)
+	for _, run := range runs {
+		if run.Archived || run.Failure == nil {
+			continue
+		}
+		config := describeFields(run.Bench, fields)
+		key := [2]string{run.Failure.Category, config}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, FailureGroup{
+				Category:   run.Failure.Category,
+				Config:     config,
+				Exceptions: make(map[string]int),
+			})
+		}
+		groups[i].Count++
+		groups[i].Exceptions[run.Failure.Exception]++
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		if groups[i].Category != groups[j].Category {
+			return groups[i].Category < groups[j].Category
+		}
+		return groups[i].Config < groups[j].Config
+	})
+	return groups
+}
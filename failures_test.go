@@ -0,0 +1,117 @@
+package main
+
+import "testing"
+
+const flinkLogging = `Oct 17 16:20:47 INFO org.apache.flink.runtime.taskexecutor.TaskExecutor - Receive slot request for job 4c1d
+Oct 17 16:20:48 INFO org.apache.flink.runtime.executiongraph.ExecutionGraph - Source: Read (1/2) switched from DEPLOYING to RUNNING.
+`
+
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    string
+		stdout    string
+		stderr    string
+		category  string
+		exception string
+	}{
+		{
+			name:   "java exception among flink logging",
+			status: StatusErr,
+			stdout: flinkLogging + `Exception in thread "main" java.lang.IllegalStateException: no results
+	at org.apache.beam.sdk.nexmark.Main.runAll(Main.java:128)
+	at org.apache.beam.sdk.nexmark.Main.main(Main.java:415)
+`,
+			category:  FailureJavaException,
+			exception: "java.lang.IllegalStateException",
+		},
+		{
+			name:   "flink job",
+			status: StatusErr,
+			stdout: flinkLogging + `Exception in thread "main" java.lang.RuntimeException: Pipeline execution failed
+	at org.apache.beam.runners.flink.FlinkRunner.run(FlinkRunner.java:90)
+Caused by: org.apache.flink.runtime.client.JobExecutionException: Job execution failed.
+	at org.apache.flink.runtime.jobmaster.JobResult.toJobExecutionResult(JobResult.java:147)
+	... 12 more
+Caused by: java.lang.IllegalArgumentException: bad window
+	at org.apache.beam.sdk.nexmark.queries.Query5.expand(Query5.java:60)
+	... 30 more
+`,
+			category:  FailureFlinkJob,
+			exception: "java.lang.IllegalArgumentException",
+		},
+		{
+			name:   "out of memory",
+			status: StatusErr,
+			stdout: flinkLogging + `Exception in thread "main" java.lang.RuntimeException: Pipeline execution failed
+	at org.apache.beam.runners.flink.FlinkRunner.run(FlinkRunner.java:90)
+Caused by: java.lang.OutOfMemoryError: Java heap space
+	at java.util.Arrays.copyOf(Arrays.java:3236)
+`,
+			category:  FailureOutOfMemory,
+			exception: "java.lang.OutOfMemoryError",
+		},
+		{
+			name:   "earlier traces don't count",
+			status: StatusErr,
+			stdout: `java.io.StreamCorruptedException: logged and recovered from
+	at java.io.ObjectInputStream.readObject(ObjectInputStream.java:400)
+` + flinkLogging + `Exception in thread "main" java.lang.IllegalStateException: no results
+	at org.apache.beam.sdk.nexmark.Main.runAll(Main.java:128)
+`,
+			category:  FailureJavaException,
+			exception: "java.lang.IllegalStateException",
+		},
+		{
+			name:   "gradle",
+			status: StatusErr,
+			stdout: flinkLogging,
+			stderr: `FAILURE: Build failed with an exception.
+
+* What went wrong:
+Execution failed for task ':sdks:java:testing:nexmark:run'.
+> Process 'command '/usr/bin/java'' finished with non-zero exit value 1
+`,
+			category: FailureGradle,
+		},
+		{
+			name:     "timeout",
+			status:   StatusTimeout,
+			stdout:   flinkLogging,
+			category: FailureTimeout,
+		},
+		{
+			name:     "unknown",
+			status:   StatusErr,
+			stdout:   flinkLogging,
+			category: FailureUnknown,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := ClassifyFailure(tt.status, []byte(tt.stdout), []byte(tt.stderr))
+			if f.Category != tt.category || f.Exception != tt.exception {
+				t.Errorf("got %s (%s), want %s (%s)", f.Category, f.Exception, tt.category, tt.exception)
+			}
+		})
+	}
+}
+
+func TestCommonException(t *testing.T) {
+	tests := []struct {
+		exceptions map[string]int
+		want       string
+	}{
+		{nil, ""},
+		{map[string]int{"": 2}, ""},
+		{map[string]int{"": 3, "java.lang.X": 1}, "java.lang.X"},
+		{map[string]int{"java.lang.A": 1, "java.lang.B": 2}, "java.lang.B"},
+		{map[string]int{"java.lang.B": 2, "java.lang.A": 2}, "java.lang.A"},
+	}
+	for _, tt := range tests {
+		g := FailureGroup{Exceptions: tt.exceptions}
+		if got := g.CommonException(); got != tt.want {
+			t.Errorf("CommonException of %v = %q, want %q", tt.exceptions, got, tt.want)
+		}
+	}
+}
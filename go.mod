@@ -6,7 +6,6 @@ require (
 	github.com/VividCortex/ewma v1.1.1 // indirect
 	github.com/google/renameio v1.0.0
 	github.com/mattn/go-isatty v0.0.12 // indirect
-	github.com/robertkrimen/otto v0.0.0-20200922221731-ef014fd054ac
 	github.com/rs/zerolog v1.20.0
 	github.com/vbauerster/mpb v3.4.0+incompatible // indirect
 	github.com/vbauerster/mpb/v6 v6.0.2
@@ -14,5 +13,4 @@ require (
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad // indirect
 	golang.org/x/sys v0.0.0-20210113181707-4bcb84eeeb78 // indirect
 	golang.org/x/term v0.0.0-20201210144234-2321bbc49cbf // indirect
-	gopkg.in/sourcemap.v1 v1.0.5 // indirect
 )
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// indexBucket maps the hash of each configuration to its runs in all series. The
+	// bucket of each hash holds the configuration and a key for every run.
+	indexBucket    = []byte("__index__")
+	indexConfigKey = []byte("config")
+	indexRunPrefix = []byte("run-")
+
+	ErrorIndexMissing = errors.New("the store has no index, open it for writing once to build it")
+)
+
+// ConfigHash returns a hash of the normalized configuration of the benchmark, which stays
+// the same as long as the command line Nexmark is run with does. Changing what configKey
+// returns for existing benchmarks needs a migration that rebuilds the index.
+func ConfigHash(b Benchmark) (string, error) {
+	key, err := configKey(b)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// benchIdentity identifies a benchmark within a series, as the n-th repetition of a configuration.
+type benchIdentity struct {
+	Hash       string
+	Repetition int
+}
+
+// benchIdentities returns the identity of each of the benchmarks.
+func benchIdentities(benches []Benchmark) ([]benchIdentity, error) {
+	reps := make(map[string]int)
+	ids := make([]benchIdentity, len(benches))
+	for i, b := range benches {
+		hash, err := ConfigHash(b)
+		if err != nil {
+			return nil, err
+		}
+		ids[i] = benchIdentity{Hash: hash, Repetition: reps[hash]}
+		reps[hash]++
+	}
+	return ids, nil
+}
+
+// ValidateSeriesID checks that the id can be used for a series. Ids starting with two
+// underscores are reserved for the store itself.
+func ValidateSeriesID(id string) error {
+	if id == "" {
+		return fmt.Errorf("series id can't be empty")
+	}
+	if strings.HasPrefix(id, "__") {
+		return fmt.Errorf("series id %q can't start with __", id)
+	}
+	return nil
+}
+
+// isSeriesBucket returns false for the top level buckets that aren't series.
+func isSeriesBucket(name []byte) bool {
+	return !bytes.HasPrefix(name, []byte("__"))
+}
+
+// A RunRef points at a benchmark in a series.
+type RunRef struct {
+	Series string
+	Bid    int
+}
+
+func (r RunRef) String() string {
+	return fmt.Sprintf("%s/%d", r.Series, r.Bid)
+}
+
+// encode returns the ref as the series id followed by the bid.
+func (r RunRef) encode() []byte {
+	return append([]byte(r.Series), itob(r.Bid)...)
+}
+
+func decodeRunRef(v []byte) RunRef {
+	n := len(v) - 8
+	return RunRef{Series: string(v[:n]), Bid: btoi(v[n:])}
+}
+
+// An IndexedRun is a run found through the index.
+type IndexedRun struct {
+	RunRef
+	Run
+}
+
+// indexBenchmark stores the hash of the benchmark with it, and adds it to the index.
+func indexBenchmark(tx *bolt.Tx, series *bolt.Bucket, ref RunRef, bench Benchmark) error {
+	hash, err := ConfigHash(bench)
+	if err != nil {
+		return err
+	}
+	if err := series.Put(append(hashPrefix, itob(ref.Bid)...), []byte(hash)); err != nil {
+		return err
+	}
+
+	index, err := tx.CreateBucketIfNotExists(indexBucket)
+	if err != nil {
+		return err
+	}
+	hb, err := index.CreateBucketIfNotExists([]byte(hash))
+	if err != nil {
+		return err
+	}
+	if hb.Get(indexConfigKey) == nil {
+		key, err := configKey(bench)
+		if err != nil {
+			return err
+		}
+		if err := hb.Put(indexConfigKey, []byte(key)); err != nil {
+			return err
+		}
+	}
+	return hb.Put(append(indexRunPrefix, ref.encode()...), []byte{})
+}
+
+// unindexSeries removes the benchmarks of the series from the index. Configurations left
+// without runs are removed too.
+func unindexSeries(tx *bolt.Tx, sid string) error {
+	series := tx.Bucket([]byte(sid))
+	index := tx.Bucket(indexBucket)
+	if series == nil || index == nil {
+		return nil
+	}
+
+	c := series.Cursor()
+	for k, v := c.Seek(hashPrefix); k != nil && bytes.HasPrefix(k, hashPrefix); k, v = c.Next() {
+		hb := index.Bucket(v)
+		if hb == nil {
+			continue
+		}
+		ref := RunRef{Series: sid, Bid: btoi(k[len(hashPrefix):])}
+		if err := hb.Delete(append(indexRunPrefix, ref.encode()...)); err != nil {
+			return err
+		}
+
+		if k, _ := hb.Cursor().Seek(indexRunPrefix); k == nil || !bytes.HasPrefix(k, indexRunPrefix) {
+			if err := index.DeleteBucket(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ensureIndex builds the index from all series if the store doesn't have one yet.
+func (s *Store) ensureIndex(tx *bolt.Tx) error {
+	if tx.Bucket(indexBucket) != nil {
+		return nil
+	}
+	s.logger.Info().Msg("Building the index of the store")
+	if _, err := tx.CreateBucket(indexBucket); err != nil {
+		return err
+	}
+
+	var sids []string
+	err := tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+		if isSeriesBucket(name) {
+			sids = append(sids, string(name))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, sid := range sids {
+		series := tx.Bucket([]byte(sid))
+		var refs []RunRef
+		var benches []Benchmark
+		c := series.Cursor()
+		for k, v := c.Seek(benchPrefix); k != nil && bytes.HasPrefix(k, benchPrefix); k, v = c.Next() {
+			var bench Benchmark
+			if err := json.Unmarshal(v, &bench); err != nil {
+				return err
+			}
+			refs = append(refs, RunRef{Series: sid, Bid: btoi(k[len(benchPrefix):])})
+			benches = append(benches, bench)
+		}
+
+		// The series is only written to once the cursor is done with it.
+		for i, ref := range refs {
+			if err := indexBenchmark(tx, series, ref, benches[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Configs returns the configuration of every hash in the index.
+func (s *Store) Configs() (map[string]Benchmark, error) {
+	configs := make(map[string]Benchmark)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket(indexBucket)
+		if index == nil {
+			return ErrorIndexMissing
+		}
+		return index.ForEach(func(hash, _ []byte) error {
+			var bench Benchmark
+			if err := json.Unmarshal(index.Bucket(hash).Get(indexConfigKey), &bench); err != nil {
+				return err
+			}
+			configs[string(hash)] = bench
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// FindRuns returns the runs of the configuration with the given hash, in all series.
+func (s *Store) FindRuns(hash string) ([]IndexedRun, error) {
+	var runs []IndexedRun
+	err := s.db.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket(indexBucket)
+		if index == nil {
+			return ErrorIndexMissing
+		}
+		hb := index.Bucket([]byte(hash))
+		if hb == nil {
+			return nil
+		}
+
+		c := hb.Cursor()
+		for k, _ := c.Seek(indexRunPrefix); k != nil && bytes.HasPrefix(k, indexRunPrefix); k, _ = c.Next() {
+			ref := decodeRunRef(k[len(indexRunPrefix):])
+			series := tx.Bucket([]byte(ref.Series))
+			if series == nil {
+				return fmt.Errorf("index refers to missing series %q", ref.Series)
+			}
+			run, err := s.readRun(series, itob(ref.Bid))
+			if err != nil {
+				return err
+			}
+			runs = append(runs, IndexedRun{RunRef: ref, Run: run})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// MatchConfigs returns the hashes of the configurations in the index that match, sorted.
+// The match is like the one of a SkipRule.
+func (s *Store) MatchConfigs(match map[string]interface{}) ([]string, error) {
+	configs, err := s.Configs()
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+	for hash, bench := range configs {
+		fields, err := benchmarkFields(bench)
+		if err != nil {
+			return nil, err
+		}
+		if matchFields(match, fields) {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+	return hashes, nil
+}
+
+// ReuseRuns gives the benchmarks of the series that haven't run the results of successful
+// runs of the same configuration in other series. Each run is reused at most once per
+// series, so repetitions get distinct runs. It returns the number of benchmarks reused for.
+func (s *Store) ReuseRuns(sid string) (int, error) {
+	reused := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		series := tx.Bucket([]byte(sid))
+		if series == nil {
+			return ErrorSeriesNotFound
+		}
+		index := tx.Bucket(indexBucket)
+		if index == nil {
+			return ErrorIndexMissing
+		}
+
+		used := make(map[RunRef]bool)
+		var todo [][]byte
+		c := series.Cursor()
+		for k, _ := c.Seek(benchPrefix); k != nil && bytes.HasPrefix(k, benchPrefix); k, _ = c.Next() {
+			bid := append([]byte(nil), k[len(benchPrefix):]...)
+			if v := series.Get(append(reusedPrefix, bid...)); v != nil {
+				used[decodeRunRef(v)] = true
+			}
+			if series.Get(append(statusPrefix, bid...)) == nil && series.Get(append(archivedPrefix, bid...)) == nil {
+				todo = append(todo, bid)
+			}
+		}
+
+		for _, bid := range todo {
+			hb := index.Bucket(series.Get(append(hashPrefix, bid...)))
+			if hb == nil {
+				continue
+			}
+
+			hc := hb.Cursor()
+			for k, _ := hc.Seek(indexRunPrefix); k != nil && bytes.HasPrefix(k, indexRunPrefix); k, _ = hc.Next() {
+				ref := decodeRunRef(k[len(indexRunPrefix):])
+				if ref.Series == sid || used[ref] {
+					continue
+				}
+				src := tx.Bucket([]byte(ref.Series))
+				if src == nil || string(src.Get(append(statusPrefix, itob(ref.Bid)...))) != StatusOK {
+					continue
+				}
+
+				for _, prefix := range runPrefixes {
+					if v := src.Get(append(prefix, itob(ref.Bid)...)); v != nil {
+						if err := series.Put(append(prefix, bid...), v); err != nil {
+							return err
+						}
+					}
+				}
+				if err := series.Put(append(reusedPrefix, bid...), ref.encode()); err != nil {
+					return err
+				}
+				s.logger.Debug().Str("series_key", sid).Int("bid", btoi(bid)).Stringer("from", ref).Msg("Reusing run")
+				used[ref] = true
+				reused++
+				break
+			}
+		}
+		return nil
+	})
+	return reused, err
+}
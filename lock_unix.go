@@ -0,0 +1,45 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// canReleaseDB is true if the writer lock is separate from the database, so that the
+// database can be closed while holding it, see Store.withoutDB.
+const canReleaseDB = true
+
+// lockStore takes the writer lock of the store at path, giving up with ErrorStoreBusy if
+// another process holds it for longer than the timeout.
+func lockStore(path string, timeout time.Duration) (*os.File, error) {
+	f, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			return f, nil
+		}
+		if err != syscall.EWOULDBLOCK || time.Now().After(deadline) {
+			f.Close()
+			if err == syscall.EWOULDBLOCK {
+				err = ErrorStoreBusy
+			}
+			return nil, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// unlockStore releases the writer lock taken by lockStore.
+func unlockStore(f *os.File) error {
+	syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return f.Close()
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os"
+	"time"
+)
+
+// Windows has no flock, so the database is the only lock, and it is kept open for as long
+// as the store is.
+const canReleaseDB = false
+
+func lockStore(path string, timeout time.Duration) (*os.File, error) {
+	return nil, nil
+}
+
+func unlockStore(f *os.File) error {
+	return nil
+}
@@ -1,27 +1,20 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/robertkrimen/otto"
 	"github.com/rs/zerolog"
 )
 
-const (
-	GradlePath = "/home/rhermes/build/beam/gradlew"
-	BeamPath   = "/home/rhermes/build/beam"
-	OutputPath = "/home/rhermes/commons/uni/thesis/beam-nexmark-benchmarks/results"
-)
-
 const (
 	PassthroughQuery                 = "PASSTHROUGH"
 	CurrencyConversionQuery          = "CURRENCY_CONVERSION"
@@ -45,6 +38,9 @@ const (
 	DefaultAveragePersonByteSize  = 200
 	DefaultAverageAuctionByteSize = 500
 	DefaultAverageBidByteSize     = 100
+
+	DefaultStreamTimeout = 60
+	DefaultDebug         = true
 )
 
 var (
@@ -108,16 +104,92 @@ type Benchmark struct {
 	CoderStrategy string
 
 	FasterCopy bool
+
+	// The options below are passed to Nexmark as --<nexmark tag>=<value> when set, in the
+	// order they are declared. Unset options keep the Nexmark defaults.
+
+	// How long to wait for a streaming job to finish, defaults to 60 seconds.
+	StreamTimeout *int `json:",omitempty"`
+	// Whether to add the monitoring operators, defaults to true.
+	Debug *bool `json:",omitempty"`
+
+	// Event rate and timing.
+	IsRateLimited         *bool    `json:",omitempty" nexmark:"isRateLimited"`
+	UseWallclockEventTime *bool    `json:",omitempty" nexmark:"useWallclockEventTime"`
+	FirstEventRate        *int     `json:",omitempty" nexmark:"firstEventRate"`
+	NextEventRate         *int     `json:",omitempty" nexmark:"nextEventRate"`
+	RateShape             *string  `json:",omitempty" nexmark:"rateShape"`
+	RateUnit              *string  `json:",omitempty" nexmark:"rateUnit"`
+	RatePeriodSec         *int     `json:",omitempty" nexmark:"ratePeriodSec"`
+	PreloadSeconds        *int     `json:",omitempty" nexmark:"preloadSeconds"`
+	OutOfOrderGroupSize   *int     `json:",omitempty" nexmark:"outOfOrderGroupSize"`
+	WatermarkHoldbackSec  *int     `json:",omitempty" nexmark:"watermarkHoldbackSec"`
+	ProbDelayedEvent      *float64 `json:",omitempty" nexmark:"probDelayedEvent"`
+	OccasionalDelaySec    *int     `json:",omitempty" nexmark:"occasionalDelaySec"`
+
+	// Shape of the generated data.
+	NumActivePeople     *int `json:",omitempty" nexmark:"numActivePeople"`
+	NumInFlightAuctions *int `json:",omitempty" nexmark:"numInFlightAuctions"`
+	HotAuctionRatio     *int `json:",omitempty" nexmark:"hotAuctionRatio"`
+	HotSellersRatio     *int `json:",omitempty" nexmark:"hotSellersRatio"`
+	HotBiddersRatio     *int `json:",omitempty" nexmark:"hotBiddersRatio"`
+
+	// Windows and query parameters.
+	WindowSizeSec          *int `json:",omitempty" nexmark:"windowSizeSec"`
+	WindowPeriodSec        *int `json:",omitempty" nexmark:"windowPeriodSec"`
+	MaxAuctionsWaitingTime *int `json:",omitempty" nexmark:"maxAuctionsWaitingTime"`
+	Fanout                 *int `json:",omitempty" nexmark:"fanout"`
+	AuctionSkip            *int `json:",omitempty" nexmark:"auctionSkip"`
+	MaxLogEvents           *int `json:",omitempty" nexmark:"maxLogEvents"`
+	CPUDelayMs             *int `json:",omitempty" nexmark:"cpuDelayMs"`
+	DiskBusyBytes          *int `json:",omitempty" nexmark:"diskBusyBytes"`
+
+	// Sources, sinks and side inputs.
+	SourceType         *string `json:",omitempty" nexmark:"sourceType"`
+	SinkType           *string `json:",omitempty" nexmark:"sinkType"`
+	SideInputType      *string `json:",omitempty" nexmark:"sideInputType"`
+	SideInputRowCount  *int    `json:",omitempty" nexmark:"sideInputRowCount"`
+	SideInputNumShards *int    `json:",omitempty" nexmark:"sideInputNumShards"`
+	SideInputURL       *string `json:",omitempty" nexmark:"sideInputUrl"`
+
+	// ExtraArgs are passed to Nexmark as --<key>=<value> after all other arguments, sorted
+	// by key. They are for pipeline and runner options that have no field of their own,
+	// like checkpointingInterval or objectReuse.
+	ExtraArgs map[string]string `json:",omitempty"`
+}
+
+// normalized returns the benchmark with the defaults Args applies filled in, so that
+// benchmarks that run Nexmark the same way are equal.
+func (b Benchmark) normalized() Benchmark {
+	if b.StreamTimeout == nil {
+		b.StreamTimeout = IntPtr(DefaultStreamTimeout)
+	}
+	if b.Debug == nil {
+		b.Debug = BoolPtr(DefaultDebug)
+	}
+	if len(b.ExtraArgs) == 0 {
+		b.ExtraArgs = nil
+	} else {
+		extra := make(map[string]string, len(b.ExtraArgs))
+		for k, v := range b.ExtraArgs {
+			extra[strings.TrimLeft(k, "-")] = v
+		}
+		b.ExtraArgs = extra
+	}
+	return b
 }
 
-func (b *Benchmark) Run(logger zerolog.Logger, gradlePath, beamPath string) ([]byte, []byte, error) {
+// Args returns the arguments Nexmark should be invoked with to run the benchmark.
+func (b *Benchmark) Args() []string {
+	n := b.normalized()
+
 	nargs := []string{
 		"--runner=FlinkRunner",
 		"--streaming",
-		"--streamTimeout=60",
+		fmt.Sprintf("--streamTimeout=%d", *n.StreamTimeout),
 		"--manageResources=false",
 		"--monitorJobs=true",
-		"--debug=true",
+		fmt.Sprintf("--debug=%t", *n.Debug),
 		fmt.Sprintf("--flinkMaster=%s", b.FlinkMaster),
 		fmt.Sprintf("--query=%s", b.Query),
 		fmt.Sprintf("--javascriptFilename=%s", b.JavascriptFilename),
@@ -144,68 +216,107 @@ func (b *Benchmark) Run(logger zerolog.Logger, gradlePath, beamPath string) ([]b
 		nargs = append(nargs, fmt.Sprintf("--avgBidByteSize=%d", *b.AverageBidByteSize))
 	}
 
-	args := []string{
-		"-p", beamPath,
-		"-Pnexmark.runner=:runners:flink:1.10",
-		// "-Pnexmark.runner=:runners:direct-java",
-		"-Pnexmark.args=" + strings.Join(nargs, "\n"),
-		":sdks:java:testing:nexmark:run",
-	}
-
-	c := exec.Command(GradlePath, args...)
+	nargs = append(nargs, b.taggedArgs()...)
+	return append(nargs, b.extraArgs()...)
+}
 
-	var stdout, stderr bytes.Buffer
-	c.Stderr = &stderr
-	c.Stdout = &stdout
+// extraArgs returns the extra arguments in a stable order.
+func (b *Benchmark) extraArgs() []string {
+	keys := make([]string, 0, len(b.ExtraArgs))
+	for k := range b.ExtraArgs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
 
-	if false {
-		c.Stderr = io.MultiWriter(os.Stderr, &stderr)
-		c.Stdout = io.MultiWriter(os.Stdout, &stdout)
+	nargs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		nargs = append(nargs, fmt.Sprintf("--%s=%s", strings.TrimLeft(k, "-"), b.ExtraArgs[k]))
 	}
-	if err := c.Run(); err != nil {
-		return stdout.Bytes(), stderr.Bytes(), err
+	return nargs
+}
+
+// taggedArgs returns the arguments for all the set fields with a nexmark tag.
+func (b *Benchmark) taggedArgs() []string {
+	var nargs []string
+	v := reflect.ValueOf(b).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		name, ok := t.Field(i).Tag.Lookup("nexmark")
+		if !ok {
+			continue
+		}
+		f := v.Field(i)
+		if f.IsNil() {
+			continue
+		}
+		nargs = append(nargs, fmt.Sprintf("--%s=%v", name, f.Elem().Interface()))
 	}
+	return nargs
+}
 
-	return stdout.Bytes(), stderr.Bytes(), nil
+// Run executes the benchmark with the given executor. If timeout is non zero the
+// invocation is killed once it has run for longer than that.
+func (b *Benchmark) Run(ctx context.Context, logger zerolog.Logger, executor Executor, timeout time.Duration) (*Execution, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return executor.Execute(ctx, logger, b.Args())
 }
 
-// Reads in the javascript file and adds the extra info to the result.
+// Reads in the javascript file and adds the extra info to the result of the query of the
+// benchmark. A truncated file is fine, as long as the entry of the query made it.
 func (b *Benchmark) AugmentResults(logger zerolog.Logger) (*Result, error) {
-	fp, err := os.Open(b.JavascriptFilename)
-	if err != nil {
-		return nil, err
-	}
-	defer fp.Close()
-	vm, _, err := otto.Run(fp)
+	rf, err := ReadResults(b.JavascriptFilename)
 	if err != nil {
 		return nil, err
 	}
-	all, err := vm.Eval("JSON.stringify(all)")
-	if err != nil {
-		return nil, err
+	if rf.Truncated {
+		logger.Warn().Str("file", b.JavascriptFilename).Int("entries", len(rf.Entries)).Msg("Results file is truncated")
 	}
 
-	var jres []JSResult
-	if err := json.Unmarshal([]byte(all.String()), &jres); err != nil {
-		return nil, err
+	var jres *JSResult
+	if len(rf.Entries) == 1 {
+		jres = &rf.Entries[0]
 	}
-
-	if len(jres) != 1 {
-		return nil, errors.New("Didn't expect more than one JSResult")
+	for i, e := range rf.Entries {
+		if e.Config.Query == b.Query {
+			jres = &rf.Entries[i]
+		}
+	}
+	if jres == nil {
+		return nil, fmt.Errorf("no results for query %s in %s", b.Query, b.JavascriptFilename)
 	}
 
-	// Return the augmented result
+	return b.augment(*jres), nil
+}
+
+// augment adds the extra info of the benchmark to the result.
+func (b *Benchmark) augment(jres JSResult) *Result {
 	return &Result{
-		JSResult: jres[0],
+		JSResult: jres,
 		Extra: Extra{
 			FasterCopy:  b.FasterCopy,
 			Parallelism: b.Parallelism,
 		},
-	}, nil
+	}
 }
 
 func main() {
-	basedir := "/home/rhermes/commons/uni/thesis/beam-nexmark-benchmarks/results"
+	flag.Usage = usage
+	settingsFile := flag.String("config", "", "settings file (default $"+SettingsFileEnv+" or ./"+DefaultSettingsFile+")")
+	var fs Settings
+	flag.StringVar(&fs.BeamPath, "beam", "", "path to the Beam checkout ($"+BeamPathEnv+")")
+	flag.StringVar(&fs.GradlePath, "gradle", "", "path to the gradle wrapper ($"+GradlePathEnv+", default <beam>/gradlew)")
+	flag.StringVar(&fs.ResultsDir, "results", "", "directory where results are exported ($"+ResultsDirEnv+", default results)")
+	flag.StringVar(&fs.DBPath, "db", "", "path to the bbolt database ($"+DBPathEnv+", default <results>/dbs/proto.db)")
+	flag.StringVar(&fs.Executor, "executor", "", "how to launch nexmark, one of gradle, java or fake ($"+ExecutorEnv+", default gradle)")
+	flag.StringVar(&fs.Runner, "runner", "", "gradle project of the runner (default "+DefaultNexmarkRunner+")")
+	flag.StringVar(&fs.JavaPath, "java", "", "java binary used by the java executor ($"+JavaPathEnv+", default java)")
+	flag.StringVar(&fs.NexmarkJar, "nexmark-jar", "", "shaded nexmark jar used by the java executor ($"+NexmarkJarEnv+")")
+	debug := flag.Bool("debug", false, "enable debug logging")
+	flag.Parse()
 
 	opts := func(w *zerolog.ConsoleWriter) {
 		w.NoColor = true
@@ -213,157 +324,174 @@ func main() {
 	}
 	logger := zerolog.New(zerolog.NewConsoleWriter(opts)).
 		With().Timestamp().Logger().Level(zerolog.InfoLevel)
-	// logger = logger.Level(zerolog.InfoLevel)
+	if *debug {
+		logger = logger.Level(zerolog.DebugLevel)
+	}
 
-	store, err := NewStore(logger, basedir+"/dbs/proto.db")
-	if err != nil {
-		logger.Fatal().Err(err).Msg("Couldn't open the store")
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
 	}
-	defer store.Close()
 
-	// if err := storeBattery(logger, store, basedir, "first", battery03GenerateBenchmarks); err != nil {
-	// 	logger.Fatal().Err(err).Msg("couldn't run the battery")
-	// }
-	// if err := storeBattery(logger, store, basedir, "bat04-06", battery04GenerateBenchmarks); err != nil {
-	// 	logger.Fatal().Err(err).Msg("couldn't run the battery")
-	// }
+	// An explicitly given settings file must exist, the default one is optional.
+	mustExist := true
+	if *settingsFile == "" {
+		*settingsFile = os.Getenv(SettingsFileEnv)
+	}
+	if *settingsFile == "" {
+		*settingsFile = DefaultSettingsFile
+		mustExist = false
+	}
+	fileSettings, err := LoadSettingsFile(*settingsFile, mustExist)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Couldn't load settings")
+	}
+	settings := fileSettings.Merge(SettingsFromEnv()).Merge(fs).WithDefaults()
 
-	// Bat05-02 - is 100 to 1000 with 100 in step.
-	// Bat05-03 - is 100 to 10000 with 200 in step.
-	if err := storeBattery(logger, store, basedir, "bat05-03", battery05GenerateBenchmarks); err != nil {
-		logger.Fatal().Err(err).Msg("couldn't run the battery")
+	app := &App{
+		logger:   logger,
+		settings: settings,
 	}
+	if err := app.Run(flag.Args()); err != nil {
+		if err == errUsage {
+			os.Exit(2)
+		}
+		logger.Fatal().Err(err).Msg("Command failed")
+	}
+}
 
+// CreateOptions controls how a battery is stored as a series.
+type CreateOptions struct {
+	// Validate is what to do with incompatible benchmarks, see ValidateBenchmarks.
+	Validate string
+	// Schedule and Seed are given to NewSchedule. When merging into a series with a saved
+	// schedule, an empty Schedule keeps its strategy and a zero Seed keeps its seed.
+	Schedule string
+	Seed     int64
+	// Merge merges into an existing series with Store.MergeSeries instead of overwriting it.
+	Merge bool
+	// Reuse fills in the results of runs in other series, see Store.ReuseRuns.
+	Reuse bool
+
+	// Meta is stored with the series. When merging, the title and description replace the
+	// existing ones if given, and the tags are added.
+	Meta SeriesMeta
 }
 
-func storeBattery(logger zerolog.Logger, store *Store, outdir, sid string, genBench func(logger zerolog.Logger) ([]Benchmark, error)) error {
-	if ok, err := store.HasSeries(sid); err != nil {
+// storeBattery generates the benchmarks of a battery and stores them as a series, or merges
+// them into it, to be run in the order given by the schedule strategy and seed. The
+// benchmarks are checked for known incompatibilities before anything is written, see
+// ValidateBenchmarks for the modes.
+func storeBattery(logger zerolog.Logger, store *Store, sid string, genBench Battery, opts CreateOptions) error {
+	existed, err := store.HasSeries(sid)
+	if err != nil {
+		return err
+	}
+
+	logger.Info().Msg("Creating series in database")
+	benches, err := genBench(logger)
+	if err != nil {
+		return err
+	}
+	logger.Info().Int("benches", len(benches)).Msg("Generated benches")
+
+	benches, err = ValidateBenchmarks(logger, benches, opts.Validate)
+	if err != nil {
 		return err
-	} else if !ok {
-		logger.Info().Msg("Creating series in database")
-		benches, err := genBench(logger)
+	}
+
+	if opts.Merge && existed && (opts.Schedule == "" || opts.Seed == 0) {
+		saved, err := store.GetSeriesSchedule(sid)
 		if err != nil {
 			return err
 		}
-		logger.Info().Int("benches", len(benches)).Msg("Generated benches")
-
-		if err := store.StoreSeries(sid, benches); err != nil {
-			return err
+		if saved != nil {
+			if opts.Schedule == "" {
+				opts.Schedule = saved.Strategy
+			}
+			if opts.Seed == 0 {
+				opts.Seed = saved.Seed
+			}
 		}
 	}
-
-	if err := store.RunSeries(sid); err != nil {
-		logger.Error().Err(err).Msg("Couldn't run series")
-		return err
+	if opts.Schedule == "" {
+		opts.Schedule = ScheduleNone
+	}
+	if opts.Seed == 0 {
+		opts.Seed = time.Now().UnixNano()
 	}
 
-	runs, err := store.GetSeriesResults(sid)
+	schedule, err := NewSchedule(opts.Schedule, opts.Seed, benches)
 	if err != nil {
-		logger.Error().Err(err).Msg("Couldn't retrieve series results")
 		return err
 	}
+	logger.Info().Str("schedule", opts.Schedule).Int64("seed", opts.Seed).Msg("Scheduled benches")
 
-	fr, err := os.Create(filepath.Join(outdir, sid+".json"))
+	if !opts.Merge {
+		if err := store.StoreSeries(sid, benches, schedule); err != nil {
+			return err
+		}
+	} else {
+		sum, err := store.MergeSeries(sid, benches, schedule)
+		if err != nil {
+			return err
+		}
+		logger.Info().
+			Int("kept", sum.Kept).
+			Int("added", sum.Added).
+			Int("archived", sum.Archived).
+			Int("restored", sum.Restored).
+			Msg("Merged series")
+	}
+
+	now := time.Now().UTC()
+	err = store.UpdateSeriesMeta(sid, func(meta *SeriesMeta) {
+		if opts.Merge && existed {
+			meta.UpdatedAt = now
+		} else {
+			meta.CreatedAt = now
+		}
+		if opts.Meta.Title != "" {
+			meta.Title = opts.Meta.Title
+		}
+		if opts.Meta.Description != "" {
+			meta.Description = opts.Meta.Description
+		}
+		meta.AddTags(opts.Meta.Tags...)
+		meta.Generator = opts.Meta.Generator
+		meta.Definition = opts.Meta.Definition
+	})
 	if err != nil {
 		return err
 	}
-	defer fr.Close()
-
-	jec := json.NewEncoder(fr)
 
-	for _, run := range runs {
-		if err := jec.Encode(run); err != nil {
+	if opts.Reuse {
+		n, err := store.ReuseRuns(sid)
+		if err != nil {
 			return err
 		}
+		logger.Info().Int("reused", n).Msg("Reused runs from other series")
 	}
-
 	return nil
 }
 
-func battery05GenerateBenchmarks(logger zerolog.Logger) ([]Benchmark, error) {
-	baseBench := Benchmark{
-		FlinkMaster:   "[local]",
-		NumEvents:     IntPtr(MAX_EVENTS * 5),
-		CoderStrategy: "HAND",
-		Parallelism:   8,
-		Query:         CurrencyConversionQuery,
-	}
-
-	var benches []Benchmark
-	mutator := VaryAvgBidSize(DefaultAverageBidByteSize, 100*DefaultAverageBidByteSize+1, 2*DefaultAverageBidByteSize)(
-		SwapFasterCopy(
-			RepeatRuns(10)(
-				ArrayBench(&benches),
-			),
-		),
-	)
-
-	if err := mutator(logger, baseBench); err != nil {
-		return nil, err
-	}
-
-	return benches, nil
-}
-
-// Battery04: Check how the difference changes parallelism is changed
-func battery04GenerateBenchmarks(logger zerolog.Logger) ([]Benchmark, error) {
-	baseBench := Benchmark{
-		FlinkMaster:   "[local]",
-		NumEvents:     IntPtr(MAX_EVENTS * 5),
-		CoderStrategy: "HAND",
-	}
-
-	var benches []Benchmark
-	mutator := UseParallelism([]int{1, 2, 4, 8})(
-		VaryQuery(NormalQueries)(
-			SwapFasterCopy(
-				RepeatRuns(10)(
-					ArrayBench(&benches),
-				),
-			),
-		),
-	)
-
-	if err := mutator(logger, baseBench); err != nil {
-		return nil, err
-	}
-
-	return benches, nil
-}
-
-func battery03GenerateBenchmarks(logger zerolog.Logger) ([]Benchmark, error) {
-	baseBench := Benchmark{
-		FlinkMaster: "[local]",
-		NumEvents:   IntPtr(MAX_EVENTS),
-		Parallelism: 2,
+// exportSeries writes all the runs of a series as JSON lines to w, optionally without
+// the runs with partial results.
+func exportSeries(store *Store, sid string, w io.Writer, excludePartial bool) error {
+	runs, err := store.GetSeriesResults(sid)
+	if err != nil {
+		return err
 	}
 
-	var benches []Benchmark
-
-	queries := AllQueries
-
-	for _, query := range queries {
-		baseBench.Query = query
-
-		coders := []string{"HAND", "AVRO", "JAVA"}
-		if query == LocalItemSuggestionQuery {
-			coders = []string{"HAND", "AVRO"}
+	jec := json.NewEncoder(w)
+	for _, run := range runs {
+		if excludePartial && run.Status == StatusPartial {
+			continue
 		}
-
-		mutator := VaryCoderStrategy(coders)(
-			SwapFasterCopy(
-				RepeatRuns(10)(
-					TimerMutator(
-						ArrayBench(&benches),
-					),
-				),
-			),
-		)
-
-		if err := mutator(logger, baseBench); err != nil {
-			return nil, err
+		if err := jec.Encode(run); err != nil {
+			return err
 		}
 	}
 
-	return benches, nil
+	return nil
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"io"
 	"time"
@@ -13,117 +14,51 @@ type Middleware func(Mutator) Mutator
 
 // Vary the number of generators.
 func VaryNumberOfGenerators(start, end, step int) Middleware {
-	return func(mut Mutator) Mutator {
-		return func(logger zerolog.Logger, b Benchmark) error {
-			for i := start; i < end; i += step {
-				b.NumEventGenerators = IntPtr(i)
-				logger := logger.With().Int("numGenerators", i).Logger()
-				if err := mut(logger, b); err != nil {
-					return err
-				}
-			}
-			return nil
-		}
-	}
+	return varyField("NumEventGenerators", "numGenerators", intRange(start, end, step))
 }
 
 func UseParallelism(vals []int) Middleware {
-	return func(mut Mutator) Mutator {
-		return func(logger zerolog.Logger, b Benchmark) error {
-			for _, val := range vals {
-				b.Parallelism = val
-				logger := logger.With().Int("parallelism", val).Logger()
-				if err := mut(logger, b); err != nil {
-					return err
-				}
-			}
-			return nil
-		}
-	}
+	return varyField("Parallelism", "parallelism", Ints(vals...))
 }
 
 func VaryAvgPersonSize(start, end, step int) Middleware {
-	return func(mut Mutator) Mutator {
-		return func(logger zerolog.Logger, b Benchmark) error {
-			for i := start; i < end; i += step {
-				b.AveragePersonByteSize = IntPtr(i)
-				logger := logger.With().Int("avgPersonByteSize", i).Logger()
-				if err := mut(logger, b); err != nil {
-					return err
-				}
-			}
-			return nil
-		}
-	}
+	return varyField("AveragePersonByteSize", "avgPersonByteSize", intRange(start, end, step))
 }
 
 func VaryAvgAuctionSize(start, end, step int) Middleware {
-	return func(mut Mutator) Mutator {
-		return func(logger zerolog.Logger, b Benchmark) error {
-			for i := start; i < end; i += step {
-				b.AverageAuctionByteSize = IntPtr(i)
-				logger := logger.With().Int("avgAuctionByteSize", i).Logger()
-				if err := mut(logger, b); err != nil {
-					return err
-				}
-			}
-			return nil
-		}
-	}
+	return varyField("AverageAuctionByteSize", "avgAuctionByteSize", intRange(start, end, step))
 }
 
 func VaryAvgBidSize(start, end, step int) Middleware {
-	return func(mut Mutator) Mutator {
-		return func(logger zerolog.Logger, b Benchmark) error {
-			for i := start; i < end; i += step {
-				b.AverageBidByteSize = IntPtr(i)
-				logger := logger.With().Int("avgBidByteSize", i).Logger()
-				if err := mut(logger, b); err != nil {
-					return err
-				}
-			}
-			return nil
-		}
-	}
+	return varyField("AverageBidByteSize", "avgBidByteSize", intRange(start, end, step))
 }
 
 func VaryParallelism(start, end, step int) Middleware {
-	return func(mut Mutator) Mutator {
-		return func(logger zerolog.Logger, b Benchmark) error {
-			for i := start; i < end; i += step {
-				b.Parallelism = i
-				logger := logger.With().Int("parallelism", i).Logger()
-				if err := mut(logger, b); err != nil {
-					return err
-				}
-			}
-			return nil
-		}
-	}
+	return varyField("Parallelism", "parallelism", intRange(start, end, step))
 }
 
 func VaryCoderStrategy(strats []string) Middleware {
-	return func(mut Mutator) Mutator {
-		return func(logger zerolog.Logger, b Benchmark) error {
-			for _, strat := range strats {
-				logger := logger.With().Str("coder", strat).Logger()
-				b.CoderStrategy = strat
-				if err := mut(logger, b); err != nil {
-					logger.Error().Err(err).Msg("Error in some sort")
-					return err
-				}
-			}
-			return nil
-		}
-	}
+	return varyField("CoderStrategy", "coder", stringValues(strats))
 }
 
 func VaryQuery(queries []string) Middleware {
+	return varyField("Query", "query", stringValues(queries))
+}
+
+// VaryExtraArg runs the mutator once for each value of the extra argument.
+func VaryExtraArg(name string, vals []string) Middleware {
 	return func(mut Mutator) Mutator {
 		return func(logger zerolog.Logger, b Benchmark) error {
-			for _, query := range queries {
-				logger := logger.With().Str("query", query).Logger()
-				b.Query = query
+			for _, val := range vals {
+				// Copy the map, so that the benchmarks don't share it.
+				extra := make(map[string]string, len(b.ExtraArgs)+1)
+				for k, v := range b.ExtraArgs {
+					extra[k] = v
+				}
+				extra[name] = val
+				b.ExtraArgs = extra
+
+				logger := logger.With().Str(name, val).Logger()
 				if err := mut(logger, b); err != nil {
 					return err
 				}
@@ -190,10 +125,10 @@ func ArrayBench(arr *[]Benchmark) Mutator {
 }
 
 // StoreBench creates a mutator that stores the results of invocations
-func StoreBench(dst io.Writer) Mutator {
+func StoreBench(dst io.Writer, executor Executor, timeout time.Duration) Mutator {
 	jwer := json.NewEncoder(dst)
 	return func(logger zerolog.Logger, bench Benchmark) error {
-		_, _, err := bench.Run(logger, GradlePath, BeamPath)
+		_, err := bench.Run(context.Background(), logger, executor, timeout)
 		if err != nil {
 			logger.Error().Err(err).Msg("Something went wrong in the writing")
 			// fmt.Printf("%s\n", gg)
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// A PreviewConfig is one distinct configuration in a battery.
+type PreviewConfig struct {
+	Bench Benchmark
+	Count int
+	// Command is the command line the executor would run.
+	Command []string
+	// SkipReason is set if the configuration matches a skip rule.
+	SkipReason string
+	// Incompatibilities lists the names of the known incompatibilities of the configuration.
+	Incompatibilities []string
+	// Runtimes are the runtimes in seconds of earlier successful runs of the configuration.
+	Runtimes []float64
+}
+
+// A Preview describes what running a battery would do, without running anything.
+type Preview struct {
+	Benches int
+	Configs []PreviewConfig
+}
+
+// NewPreview groups the benchmarks by configuration. The executor is used to build the
+// command lines, history holds earlier runtimes as returned by Store.RuntimeHistory and
+// can be nil.
+func NewPreview(benches []Benchmark, executor Executor, rules []SkipRule, history map[string][]float64) (*Preview, error) {
+	groups, err := groupBenchmarks(benches, configKey)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Preview{Benches: len(benches)}
+	for _, g := range groups {
+		bench := benches[g[0]]
+		bench.JavascriptFilename = javascriptFilename("<run dir>")
+
+		pc := PreviewConfig{
+			Bench: bench,
+			Count: len(g),
+		}
+		if cb, ok := executor.(CommandBuilder); ok {
+			pc.Command = cb.Command(bench.Args())
+		}
+		if pc.SkipReason, err = SkipReason(rules, bench); err != nil {
+			return nil, err
+		}
+		for _, inc := range Incompatibilities {
+			if inc.Check(bench) {
+				pc.Incompatibilities = append(pc.Incompatibilities, inc.Name)
+			}
+		}
+		key, err := configKey(bench)
+		if err != nil {
+			return nil, err
+		}
+		pc.Runtimes = history[key]
+
+		p.Configs = append(p.Configs, pc)
+	}
+	return p, nil
+}
+
+// Estimate returns the estimated total duration of the runs that won't be skipped. Configurations
+// without history are assumed to take the mean of those with. known and unknown are the
+// number of configurations with and without history.
+func (p *Preview) Estimate() (total time.Duration, known, unknown int) {
+	var sum float64
+	var runs int
+	for _, pc := range p.Configs {
+		if pc.SkipReason != "" {
+			continue
+		}
+		if len(pc.Runtimes) == 0 {
+			unknown++
+			continue
+		}
+		known++
+		sum += mean(pc.Runtimes) * float64(pc.Count)
+		runs += pc.Count
+	}
+	if runs == 0 {
+		return 0, known, unknown
+	}
+
+	perRun := sum / float64(runs)
+	for _, pc := range p.Configs {
+		if pc.SkipReason == "" && len(pc.Runtimes) == 0 {
+			sum += perRun * float64(pc.Count)
+		}
+	}
+	return secondsToDuration(sum), known, unknown
+}
+
+// Print writes the preview as a table of the configurations, followed by their command lines.
+func (p *Preview) Print(w io.Writer) error {
+	fields := p.varyingFields()
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "#\tCOUNT\t%s\tEST/RUN\tNOTES\n", strings.Join(fields, "\t"))
+	for i, pc := range p.Configs {
+		vals, err := benchmarkFields(pc.Bench)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(tw, "%d\t%d\t", i, pc.Count)
+		for _, f := range fields {
+			fmt.Fprintf(tw, "%s\t", formatFieldValue(vals[f]))
+		}
+
+		est := "-"
+		if len(pc.Runtimes) > 0 {
+			est = secondsToDuration(mean(pc.Runtimes)).String()
+		}
+		var notes []string
+		if pc.SkipReason != "" {
+			notes = append(notes, "skipped: "+pc.SkipReason)
+		}
+		for _, inc := range pc.Incompatibilities {
+			notes = append(notes, "incompatible: "+inc)
+		}
+		fmt.Fprintf(tw, "%s\t%s\n", est, strings.Join(notes, "; "))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	for i, pc := range p.Configs {
+		if pc.Command == nil {
+			continue
+		}
+		fmt.Fprintf(w, "#%d: %s\n", i, shellJoin(pc.Command))
+	}
+
+	total, known, unknown := p.Estimate()
+	fmt.Fprintf(w, "\n%d benchmarks in %d configurations.\n", p.Benches, len(p.Configs))
+	switch {
+	case known == 0:
+		fmt.Fprintf(w, "No history to estimate the duration from.\n")
+	case unknown == 0:
+		fmt.Fprintf(w, "Estimated duration: %s.\n", total)
+	default:
+		fmt.Fprintf(w, "Estimated duration: %s, %d configurations without history are assumed to take the mean.\n", total, unknown)
+	}
+	return nil
+}
+
+// varyingFields returns the benchmark fields that differ between the configurations, in
+// the order they are declared in. If there is only one configuration its set fields are returned.
+func (p *Preview) varyingFields() []string {
+	benches := make([]Benchmark, len(p.Configs))
+	for i, pc := range p.Configs {
+		benches[i] = pc.Bench
+	}
+	return varyingFields(benches)
+}
+
+// varyingFields returns the fields that differ between the benchmarks, in the order they
+// are declared in. If there is only one benchmark its set fields are returned.
+func varyingFields(benches []Benchmark) []string {
+	var all []map[string]interface{}
+	for _, b := range benches {
+		vals, err := benchmarkFields(b)
+		if err != nil {
+			continue
+		}
+		all = append(all, vals)
+	}
+
+	var fields []string
+	t := reflect.TypeOf(Benchmark{})
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		if len(all) == 0 {
+			break
+		}
+		first, ok := all[0][name]
+		if !ok {
+			first = nil
+		}
+
+		varies := len(all) == 1 && first != nil
+		for _, vals := range all[1:] {
+			if !reflect.DeepEqual(vals[name], first) {
+				varies = true
+				break
+			}
+		}
+		if varies {
+			fields = append(fields, name)
+		}
+	}
+	return fields
+}
+
+// describeFields describes the benchmark by the values of the fields, like "Query=PASSTHROUGH Parallelism=2".
+func describeFields(b Benchmark, fields []string) string {
+	vals, err := benchmarkFields(b)
+	if err != nil {
+		return "?"
+	}
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = f + "=" + formatFieldValue(vals[f])
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatFieldValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "-"
+	case string:
+		return v
+	default:
+		data, _ := json.Marshal(v)
+		return string(data)
+	}
+}
+
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// shellJoin quotes the arguments so that they can be pasted into bash. Arguments with
+// newlines, like the nexmark args given to gradle, use $'...' quoting to stay on one line.
+func shellJoin(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		switch {
+		case shellSafe.MatchString(arg):
+			quoted[i] = arg
+		case strings.Contains(arg, "\n"):
+			r := strings.NewReplacer(`\`, `\\`, "'", `\'`, "\n", `\n`)
+			quoted[i] = "$'" + r.Replace(arg) + "'"
+		default:
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+func mean(xs []float64) float64 {
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second)).Round(time.Second)
+}
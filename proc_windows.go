@@ -0,0 +1,12 @@
+package main
+
+import (
+	"os/exec"
+)
+
+// Windows has no process groups that can be killed like this, so only the command itself is killed.
+func setProcessGroup(c *exec.Cmd) {}
+
+func killProcessGroup(c *exec.Cmd) error {
+	return c.Process.Kill()
+}
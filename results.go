@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// ErrorNoResultsArray is returned for files that aren't Nexmark results files.
+var ErrorNoResultsArray = errors.New("no \"var all = [\" in the results file")
+
+// A ResultsFile is the content of a file Nexmark wrote because of --javascriptFilename.
+// It is a javascript file of the form
+//
+//	var all = [
+//	  {
+//	    config: {...}
+//	    ,perf: {...}
+//	  },
+//	];
+//
+// with one entry for each query that was run.
+type ResultsFile struct {
+	Entries []JSResult
+	// Truncated is set if the file ended before the array did, like when Nexmark crashed
+	// while writing it. Entries only holds the entries that were complete.
+	Truncated bool
+}
+
+var resultsArrayStart = regexp.MustCompile(`var\s+all\s*=\s*\[`)
+
+// ReadResults parses the results file at the path.
+func ReadResults(path string) (*ResultsFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	rf, err := ParseResults(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return rf, nil
+}
+
+// ParseResults parses a results file without evaluating it. The entries are objects with
+// unquoted keys and JSON values, which is all Nexmark writes.
+func ParseResults(data []byte) (*ResultsFile, error) {
+	rf := &ResultsFile{}
+	// Nexmark may have crashed before it even wrote the start of the array.
+	if strings.HasPrefix("var all = [", strings.TrimSpace(string(data))) {
+		rf.Truncated = true
+		return rf, nil
+	}
+
+	loc := resultsArrayStart.FindIndex(data)
+	if loc == nil {
+		return nil, ErrorNoResultsArray
+	}
+
+	p := &resultsParser{data: data, pos: loc[1]}
+	for {
+		p.skip(" \t\r\n,")
+		if p.eof() {
+			rf.Truncated = true
+			return rf, nil
+		}
+
+		switch c := p.data[p.pos]; c {
+		case ']':
+			return rf, nil
+		case '{':
+			entry, complete, err := p.entry()
+			if err != nil {
+				return nil, err
+			}
+			if !complete {
+				rf.Truncated = true
+				return rf, nil
+			}
+			rf.Entries = append(rf.Entries, entry)
+		default:
+			return nil, fmt.Errorf("unexpected %q at offset %d", c, p.pos)
+		}
+	}
+}
+
+type resultsParser struct {
+	data []byte
+	pos  int
+}
+
+func (p *resultsParser) eof() bool {
+	return p.pos >= len(p.data)
+}
+
+// skip moves past any of the characters in set.
+func (p *resultsParser) skip(set string) {
+	for !p.eof() && strings.IndexByte(set, p.data[p.pos]) >= 0 {
+		p.pos++
+	}
+}
+
+// entry parses one object of the array, starting at its opening brace. complete is false
+// if the data ended before the object did.
+func (p *resultsParser) entry() (entry JSResult, complete bool, err error) {
+	fields := make(map[string]json.RawMessage)
+	p.pos++
+	for {
+		p.skip(" \t\r\n,")
+		if p.eof() {
+			return entry, false, nil
+		}
+		if p.data[p.pos] == '}' {
+			p.pos++
+			break
+		}
+
+		key, err := p.key()
+		if err != nil || p.eof() {
+			return entry, false, err
+		}
+		p.skip(" \t\r\n")
+		if p.eof() {
+			return entry, false, nil
+		}
+		if p.data[p.pos] != ':' {
+			return entry, false, fmt.Errorf("expected ':' after %q at offset %d", key, p.pos)
+		}
+		p.pos++
+		p.skip(" \t\r\n")
+
+		start := p.pos
+		if !p.value() {
+			return entry, false, nil
+		}
+		fields[key] = jsToJSON(p.data[start:p.pos])
+	}
+
+	obj, err := json.Marshal(fields)
+	if err != nil {
+		return entry, false, err
+	}
+	if err := json.Unmarshal(obj, &entry); err != nil {
+		return entry, false, err
+	}
+	return entry, true, nil
+}
+
+// key parses an unquoted or quoted object key.
+func (p *resultsParser) key() (string, error) {
+	start := p.pos
+	if p.data[p.pos] == '"' {
+		if !p.value() {
+			return "", nil
+		}
+		var key string
+		err := json.Unmarshal(p.data[start:p.pos], &key)
+		return key, err
+	}
+
+	for !p.eof() && isIdentByte(p.data[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", fmt.Errorf("expected a key at offset %d, found %q", p.pos, p.data[p.pos])
+	}
+	return string(p.data[start:p.pos]), nil
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// value moves past one JSON value and returns false if the data ended before it did.
+func (p *resultsParser) value() bool {
+	depth := 0
+	inString := false
+	for ; !p.eof(); p.pos++ {
+		c := p.data[p.pos]
+		if inString {
+			switch c {
+			case '\\':
+				p.pos++
+			case '"':
+				inString = false
+				if depth == 0 {
+					p.pos++
+					return true
+				}
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			if depth == 0 {
+				return true
+			}
+			depth--
+			if depth == 0 {
+				p.pos++
+				return true
+			}
+		case ',', '\n', '\r':
+			if depth == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var nonFiniteNumber = regexp.MustCompile(`-?\b(NaN|Infinity)\b`)
+
+// jsToJSON turns the NaN and Infinity javascript allows into nulls, like JSON.stringify
+// does. Values that are valid JSON are returned as they are.
+func jsToJSON(v []byte) json.RawMessage {
+	v = []byte(strings.TrimSpace(string(v)))
+	if json.Valid(v) {
+		return v
+	}
+
+	// Only replace outside of strings, which are the odd parts when splitting on quotes.
+	// Escaped quotes don't matter, as the values are only numbers in practice.
+	parts := strings.Split(string(v), `"`)
+	for i := 0; i < len(parts); i += 2 {
+		parts[i] = nonFiniteNumber.ReplaceAllString(parts[i], "null")
+	}
+	return json.RawMessage(strings.Join(parts, `"`))
+}
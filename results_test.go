@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "results", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func parseFixture(t *testing.T, name string) *ResultsFile {
+	t.Helper()
+	rf, err := ParseResults(readFixture(t, name))
+	if err != nil {
+		t.Fatalf("parsing %s: %v", name, err)
+	}
+	return rf
+}
+
+func queries(entries []JSResult) []string {
+	var qs []string
+	for _, e := range entries {
+		qs = append(qs, e.Config.Query)
+	}
+	return qs
+}
+
+func TestParseResultsSingle(t *testing.T) {
+	rf := parseFixture(t, "single.js")
+	if rf.Truncated {
+		t.Error("complete file is reported as truncated")
+	}
+	if len(rf.Entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(rf.Entries))
+	}
+
+	e := rf.Entries[0]
+	if e.Config.Query != PassthroughQuery || e.Config.NumEvents != 991683 || e.Config.CoderStrategy != "HAND" {
+		t.Errorf("wrong config: %+v", e.Config)
+	}
+	if e.Config.SessionGap.StandardMinutes != 10 {
+		t.Errorf("got session gap %+v, want 10 minutes", e.Config.SessionGap)
+	}
+	if e.Perf.RuntimeSec != 11.735 || e.Perf.NumResults != 991683 || e.Perf.ProcessingDelaySec != -1 {
+		t.Errorf("wrong perf: %+v", e.Perf)
+	}
+	want := []Snapshots{
+		{NumEvents: 412000, NumResults: 412000, RuntimeSec: 5, SecSinceStart: 5},
+		{NumEvents: 850000, NumResults: 850000, RuntimeSec: 10, SecSinceStart: 10},
+	}
+	if !reflect.DeepEqual(e.Perf.Snapshots, want) {
+		t.Errorf("got snapshots %+v, want %+v", e.Perf.Snapshots, want)
+	}
+}
+
+func TestParseResultsMultiple(t *testing.T) {
+	rf := parseFixture(t, "multiple.js")
+	if rf.Truncated {
+		t.Error("complete file is reported as truncated")
+	}
+	want := []string{PassthroughQuery, CurrencyConversionQuery, SelectionQuery}
+	if got := queries(rf.Entries); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got queries %v, want %v", got, want)
+	}
+	if got := rf.Entries[2].Perf.NumResults; got != 8840 {
+		t.Errorf("got %d results for %s, want 8840", got, SelectionQuery)
+	}
+}
+
+func TestParseResultsNonFinite(t *testing.T) {
+	rf := parseFixture(t, "nonfinite.js")
+	if rf.Truncated || len(rf.Entries) != 1 {
+		t.Fatalf("got %d entries, truncated %t, want 1 complete entry", len(rf.Entries), rf.Truncated)
+	}
+
+	// NaN and Infinity become null, like JSON.stringify makes them, and so zero.
+	p := rf.Entries[0].Perf
+	if p.EventsPerSec != 0 || p.ResultsPerSec != 0 || p.ProcessingDelaySec != 0 {
+		t.Errorf("non finite numbers weren't zeroed: %+v", p)
+	}
+	if p.RuntimeSec != 14.2 || p.NumEvents != 991683 {
+		t.Errorf("wrong perf: %+v", p)
+	}
+}
+
+func TestParseResultsTruncated(t *testing.T) {
+	data := string(readFixture(t, "multiple.js"))
+	entry := func(n int) int {
+		i := -1
+		for ; n >= 0; n-- {
+			i += 1 + strings.Index(data[i+1:], "  {\n")
+		}
+		return i
+	}
+	end := strings.Index(data, "];")
+
+	tests := []struct {
+		name    string
+		cut     int
+		queries []string
+	}{
+		{"empty", 0, nil},
+		{"in var", len("var al"), nil},
+		{"after the bracket", len("var all = ["), nil},
+		{"in the first config", entry(0) + 40, nil},
+		{"in the second config", entry(1) + 40, []string{PassthroughQuery}},
+		{"in the third perf", strings.LastIndex(data, ",perf:") + 30, []string{PassthroughQuery, CurrencyConversionQuery}},
+		{"before the end", end, []string{PassthroughQuery, CurrencyConversionQuery, SelectionQuery}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rf, err := ParseResults([]byte(data[:tt.cut]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !rf.Truncated {
+				t.Error("not reported as truncated")
+			}
+			if got := queries(rf.Entries); !reflect.DeepEqual(got, tt.queries) {
+				t.Errorf("got queries %v, want %v", got, tt.queries)
+			}
+		})
+	}
+}
+
+func TestParseResultsEveryCut(t *testing.T) {
+	data := readFixture(t, "multiple.js")
+	full := parseFixture(t, "multiple.js")
+	end := strings.Index(string(data), "];")
+
+	for cut := 0; cut <= end; cut++ {
+		rf, err := ParseResults(data[:cut])
+		if err != nil {
+			t.Fatalf("cut at %d: %v", cut, err)
+		}
+		if !rf.Truncated {
+			t.Fatalf("cut at %d: not reported as truncated", cut)
+		}
+		if len(rf.Entries) > 0 && !reflect.DeepEqual(rf.Entries, full.Entries[:len(rf.Entries)]) {
+			t.Fatalf("cut at %d: entries differ from the complete file", cut)
+		}
+	}
+}
+
+func TestParseResultsNotResults(t *testing.T) {
+	if _, err := ParseResults([]byte("Exception in thread \"main\"\n")); !errors.Is(err, ErrorNoResultsArray) {
+		t.Errorf("got error %v, want %v", err, ErrorNoResultsArray)
+	}
+}
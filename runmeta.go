@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// FlinkConfDirEnv is where the Flink configuration in effect is read from.
+const FlinkConfDirEnv = "FLINK_CONF_DIR"
+
+// A RunEnv describes the environment the benchmarks of a series are run in. It is
+// collected once when the series starts running, empty fields couldn't be determined.
+type RunEnv struct {
+	Host     string
+	Executor string
+	Runner   string `json:",omitempty"`
+
+	// BeamCommit is the commit the Beam checkout is at, and BeamDirty whether it has local changes.
+	BeamCommit string `json:",omitempty"`
+	BeamDirty  *bool  `json:",omitempty"`
+
+	// JavaVersion is the first line of java -version.
+	JavaVersion string `json:",omitempty"`
+
+	// FlinkConf is the flink-conf.yaml in FLINK_CONF_DIR, if set.
+	FlinkConf map[string]string `json:",omitempty"`
+}
+
+// A RunMeta is stored with each run, it says when, where and with what the benchmark ran.
+type RunMeta struct {
+	RunEnv
+
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// WallSec is the wall clock duration of the whole invocation, including gradle and the JVM startup.
+	WallSec  float64
+	ExitCode int
+	// RunDir is the directory with the artifacts of the run.
+	RunDir string `json:",omitempty"`
+}
+
+// Environment collects the environment the benchmarks will run in.
+func (s Settings) Environment(ctx context.Context, logger zerolog.Logger) RunEnv {
+	env := RunEnv{
+		Executor: s.Executor,
+	}
+	if s.Executor == ExecutorGradle {
+		env.Runner = s.Runner
+	}
+
+	var err error
+	if env.Host, err = os.Hostname(); err != nil {
+		logger.Debug().Err(err).Msg("Couldn't get the hostname")
+	}
+
+	if s.BeamPath != "" {
+		if out, err := commandOutput(ctx, "git", "-C", s.BeamPath, "rev-parse", "HEAD"); err != nil {
+			logger.Debug().Err(err).Msg("Couldn't get the commit of the Beam checkout")
+		} else {
+			env.BeamCommit = strings.TrimSpace(out)
+		}
+		if out, err := commandOutput(ctx, "git", "-C", s.BeamPath, "status", "--porcelain"); err != nil {
+			logger.Debug().Err(err).Msg("Couldn't get the state of the Beam checkout")
+		} else {
+			env.BeamDirty = BoolPtr(strings.TrimSpace(out) != "")
+		}
+	}
+
+	if java := s.benchmarkJava(); java != "" {
+		// java prints its version on stderr.
+		c := exec.CommandContext(ctx, java, "-version")
+		if out, err := c.CombinedOutput(); err != nil {
+			logger.Debug().Err(err).Msg("Couldn't get the java version")
+		} else {
+			env.JavaVersion = strings.TrimSpace(strings.SplitN(string(out), "\n", 2)[0])
+		}
+	}
+
+	if dir := os.Getenv(FlinkConfDirEnv); dir != "" {
+		if env.FlinkConf, err = readFlinkConf(filepath.Join(dir, "flink-conf.yaml")); err != nil {
+			logger.Warn().Err(err).Msg("Couldn't read the Flink configuration")
+		}
+	}
+	return env
+}
+
+// benchmarkJava returns the java binary the benchmarks run on, or "" if they don't run on
+// java. Gradle runs them with the JVM it runs on, which is the one in JAVA_HOME, or the
+// one in PATH if that isn't set.
+func (s Settings) benchmarkJava() string {
+	switch s.Executor {
+	case ExecutorJava:
+		return s.JavaPath
+	case ExecutorGradle:
+		if home := os.Getenv("JAVA_HOME"); home != "" {
+			return filepath.Join(home, "bin", "java")
+		}
+		return "java"
+	default:
+		return ""
+	}
+}
+
+func commandOutput(ctx context.Context, name string, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, name, args...).Output()
+	return string(out), err
+}
+
+// readFlinkConf reads a flink-conf.yaml. Flink only supports flat "key: value" lines in
+// it, so this is all the YAML that needs to be understood.
+func readFlinkConf(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	conf := make(map[string]string)
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		conf[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return conf, sc.Err()
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+)
+
+const (
+	// ScheduleNone runs the benchmarks in the order they were generated.
+	ScheduleNone = "none"
+	// ScheduleShuffle runs the benchmarks in a completely random order.
+	ScheduleShuffle = "shuffle"
+	// ScheduleBlocked runs the repetitions as blocks, each a shuffled pass over all configurations.
+	ScheduleBlocked = "blocked"
+	// ScheduleInterleave pairs up the runs with and without FasterCopy and runs each pair
+	// back to back, A B A B, with the pairs blocked like ScheduleBlocked.
+	ScheduleInterleave = "interleave"
+)
+
+// Schedules lists the valid scheduling strategies.
+var Schedules = []string{ScheduleNone, ScheduleShuffle, ScheduleBlocked, ScheduleInterleave}
+
+// A Schedule is the order the benchmarks of a series are run in, together with how it was
+// made, so that the order can be recreated.
+type Schedule struct {
+	Strategy string `json:"strategy"`
+	Seed     int64  `json:"seed"`
+	// Order is the indices of the benchmarks, in the order they are to be run.
+	Order []int `json:"order"`
+}
+
+// NewSchedule orders the benchmarks with the strategy, using seed for the randomness.
+func NewSchedule(strategy string, seed int64, benches []Benchmark) (*Schedule, error) {
+	rnd := rand.New(rand.NewSource(seed))
+
+	var order []int
+	switch strategy {
+	case ScheduleNone:
+		for i := range benches {
+			order = append(order, i)
+		}
+	case ScheduleShuffle:
+		order = rnd.Perm(len(benches))
+	case ScheduleBlocked:
+		groups, err := groupBenchmarks(benches, configKey)
+		if err != nil {
+			return nil, err
+		}
+		order = blocked(rnd, groups, 1)
+	case ScheduleInterleave:
+		groups, err := interleavedPairs(benches)
+		if err != nil {
+			return nil, err
+		}
+		order = blocked(rnd, groups, 2)
+	default:
+		return nil, fmt.Errorf("unknown schedule %q", strategy)
+	}
+
+	return &Schedule{
+		Strategy: strategy,
+		Seed:     seed,
+		Order:    order,
+	}, nil
+}
+
+// configKey identifies the configuration of a benchmark, repetitions of it have the same key.
+// Benchmarks are normalized first, so that leaving out an option and giving its default
+// gives the same key.
+func configKey(b Benchmark) (string, error) {
+	data, err := json.Marshal(b.normalized())
+	return string(data), err
+}
+
+// pairKey is like configKey, but ignores FasterCopy, so that the two variants are paired.
+func pairKey(b Benchmark) (string, error) {
+	b.FasterCopy = false
+	return configKey(b)
+}
+
+// groupBenchmarks groups the indices of the benchmarks by key, in order of first appearance.
+func groupBenchmarks(benches []Benchmark, key func(Benchmark) (string, error)) ([][]int, error) {
+	idx := make(map[string]int)
+	var groups [][]int
+	for i, b := range benches {
+		k, err := key(b)
+		if err != nil {
+			return nil, err
+		}
+		g, ok := idx[k]
+		if !ok {
+			g = len(groups)
+			idx[k] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups, nil
+}
+
+// interleavedPairs groups the benchmarks like groupBenchmarks, but with each group holding
+// the runs without and with FasterCopy alternating. Runs left without a partner come last.
+func interleavedPairs(benches []Benchmark) ([][]int, error) {
+	groups, err := groupBenchmarks(benches, pairKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for gi, g := range groups {
+		var as, bs []int
+		for _, i := range g {
+			if benches[i].FasterCopy {
+				bs = append(bs, i)
+			} else {
+				as = append(as, i)
+			}
+		}
+
+		var pairs []int
+		for len(as) > 0 && len(bs) > 0 {
+			pairs = append(pairs, as[0], bs[0])
+			as, bs = as[1:], bs[1:]
+		}
+		groups[gi] = append(append(pairs, as...), bs...)
+	}
+	return groups, nil
+}
+
+// blocked makes passes over the groups, taking the next unit of size indices from each
+// group in every pass. The order of the units within a pass is shuffled.
+func blocked(rnd *rand.Rand, groups [][]int, size int) []int {
+	var order []int
+	for pass := 0; ; pass++ {
+		var units [][]int
+		for _, g := range groups {
+			start := pass * size
+			if start >= len(g) {
+				continue
+			}
+			end := start + size
+			if end > len(g) {
+				end = len(g)
+			}
+			units = append(units, g[start:end])
+		}
+		if len(units) == 0 {
+			return order
+		}
+
+		rnd.Shuffle(len(units), func(i, j int) {
+			units[i], units[j] = units[j], units[i]
+		})
+		for _, u := range units {
+			order = append(order, u...)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	// metaBucket holds data about the store itself, like the schema version.
+	metaBucket       = []byte("__meta__")
+	schemaVersionKey = []byte("schema_version")
+
+	ErrorSchemaOutdated = errors.New("the store uses an older schema")
+)
+
+// A migration brings the store from the previous schema version to Version.
+type migration struct {
+	Version     int
+	Description string
+	Apply       func(s *Store, tx *bolt.Tx) error
+}
+
+// migrations are applied in order to stores with an older schema version. Stores from
+// before the versioning have version 0. Append new migrations at the end, and never
+// change the ones that have been released.
+var migrations = []migration{
+	{
+		Version:     1,
+		Description: "index the benchmarks by the hash of their configuration",
+		Apply:       (*Store).ensureIndex,
+	},
+}
+
+// SchemaVersion is the schema version of stores written by this version of the program.
+var SchemaVersion = migrations[len(migrations)-1].Version
+
+// schemaVersion returns the schema version of the store.
+func schemaVersion(tx *bolt.Tx) int {
+	meta := tx.Bucket(metaBucket)
+	if meta == nil {
+		return 0
+	}
+	v := meta.Get(schemaVersionKey)
+	if v == nil {
+		return 0
+	}
+	return btoi(v)
+}
+
+func setSchemaVersion(tx *bolt.Tx, version int) error {
+	meta, err := tx.CreateBucketIfNotExists(metaBucket)
+	if err != nil {
+		return err
+	}
+	return meta.Put(schemaVersionKey, itob(version))
+}
+
+// migrate applies the migrations the store is missing. All of them are applied in the
+// transaction, so a failing migration leaves the store as it was.
+func (s *Store) migrate(tx *bolt.Tx) error {
+	version := schemaVersion(tx)
+	if version > SchemaVersion {
+		return fmt.Errorf("the store has schema version %d, but only up to %d is supported", version, SchemaVersion)
+	}
+
+	for _, m := range migrations {
+		if m.Version <= version {
+			continue
+		}
+		s.logger.Info().Int("version", m.Version).Str("migration", m.Description).Msg("Migrating store")
+		if err := m.Apply(s, tx); err != nil {
+			return fmt.Errorf("migrating store to version %d: %w", m.Version, err)
+		}
+		if err := setSchemaVersion(tx, m.Version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSchema returns an error if the store isn't at the current schema version.
+func checkSchema(tx *bolt.Tx) error {
+	version := schemaVersion(tx)
+	switch {
+	case version < SchemaVersion:
+		return fmt.Errorf("%w (version %d, current is %d)", ErrorSchemaOutdated, version, SchemaVersion)
+	case version > SchemaVersion:
+		return fmt.Errorf("the store has schema version %d, but only up to %d is supported", version, SchemaVersion)
+	}
+	return nil
+}
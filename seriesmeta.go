@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var seriesMetaKey = []byte("series-meta")
+
+// SeriesMeta describes what a series is for, and where it came from.
+type SeriesMeta struct {
+	Title       string   `json:",omitempty"`
+	Description string   `json:",omitempty"`
+	Tags        []string `json:",omitempty"`
+
+	CreatedAt time.Time
+	UpdatedAt time.Time `json:",omitempty"`
+
+	// Generator is the name of the battery, or the path of the battery definition file,
+	// the series was generated from.
+	Generator string `json:",omitempty"`
+	// Definition is the battery definition, for series generated from a file.
+	Definition json.RawMessage `json:",omitempty"`
+}
+
+// HasTag returns true if the series is tagged with the tag.
+func (m SeriesMeta) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// AddTags adds the tags the series doesn't have yet, and keeps them sorted.
+func (m *SeriesMeta) AddTags(tags ...string) {
+	for _, tag := range tags {
+		if !m.HasTag(tag) {
+			m.Tags = append(m.Tags, tag)
+		}
+	}
+	sort.Strings(m.Tags)
+}
+
+// RemoveTags removes the tags from the series.
+func (m *SeriesMeta) RemoveTags(tags ...string) {
+	var kept []string
+	for _, t := range m.Tags {
+		remove := false
+		for _, tag := range tags {
+			if t == tag {
+				remove = true
+				break
+			}
+		}
+		if !remove {
+			kept = append(kept, t)
+		}
+	}
+	m.Tags = kept
+}
+
+// GetSeriesMeta returns the metadata of the series. Series from before metadata was stored
+// get the zero value.
+func (s *Store) GetSeriesMeta(sid string) (SeriesMeta, error) {
+	var meta SeriesMeta
+	err := s.db.View(func(tx *bolt.Tx) error {
+		series := tx.Bucket([]byte(sid))
+		if series == nil {
+			return ErrorSeriesNotFound
+		}
+		if v := series.Get(seriesMetaKey); v != nil {
+			return json.Unmarshal(v, &meta)
+		}
+		return nil
+	})
+	return meta, err
+}
+
+// UpdateSeriesMeta changes the metadata of the series with the function.
+func (s *Store) UpdateSeriesMeta(sid string, update func(meta *SeriesMeta)) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		series := tx.Bucket([]byte(sid))
+		if series == nil {
+			return ErrorSeriesNotFound
+		}
+
+		var meta SeriesMeta
+		if v := series.Get(seriesMetaKey); v != nil {
+			if err := json.Unmarshal(v, &meta); err != nil {
+				return err
+			}
+		}
+		update(&meta)
+
+		data, err := json.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		return series.Put(seriesMetaKey, data)
+	})
+}
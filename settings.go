@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const (
+	// DefaultSettingsFile is read from the working directory if no other file is given.
+	DefaultSettingsFile = "nexbench.json"
+
+	SettingsFileEnv = "NEXBENCH_CONFIG"
+	BeamPathEnv     = "NEXBENCH_BEAM_PATH"
+	GradlePathEnv   = "NEXBENCH_GRADLE_PATH"
+	ResultsDirEnv   = "NEXBENCH_RESULTS_DIR"
+	DBPathEnv       = "NEXBENCH_DB"
+	ExecutorEnv     = "NEXBENCH_EXECUTOR"
+	NexmarkJarEnv   = "NEXBENCH_NEXMARK_JAR"
+	JavaPathEnv     = "NEXBENCH_JAVA"
+
+	ExecutorGradle = "gradle"
+	ExecutorJava   = "java"
+	ExecutorFake   = "fake"
+)
+
+// Settings describes where the tool finds Beam and where it stores its results. They are
+// resolved from, in increasing order of precedence, the defaults, a settings file,
+// the environment and the command line flags.
+type Settings struct {
+	// BeamPath is the root of the Beam checkout.
+	BeamPath string `json:"beam_path"`
+	// GradlePath is the gradle wrapper to use, defaults to gradlew in the Beam checkout.
+	GradlePath string `json:"gradle_path"`
+	// ResultsDir is where exported results are written.
+	ResultsDir string `json:"results_dir"`
+	// DBPath is the bbolt database, defaults to dbs/proto.db in the results dir.
+	DBPath string `json:"db_path"`
+
+	// Executor selects how Nexmark is launched, one of gradle, java or fake.
+	Executor string `json:"executor"`
+	// Runner is the gradle project of the runner used by the gradle executor.
+	Runner string `json:"runner"`
+	// JavaPath is the java binary used by the java executor.
+	JavaPath string `json:"java_path"`
+	// NexmarkJar is the shaded Nexmark jar, including the runner, used by the java executor.
+	NexmarkJar string `json:"nexmark_jar"`
+
+	// SkipRules decide which benchmarks are not run. If not set, DefaultSkipRules are used.
+	SkipRules []SkipRule `json:"skip_rules"`
+}
+
+// LoadSettingsFile reads settings from a JSON file. If the file doesn't exist and
+// mustExist is false, empty settings are returned.
+func LoadSettingsFile(path string, mustExist bool) (Settings, error) {
+	var s Settings
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !mustExist {
+		return s, nil
+	} else if err != nil {
+		return s, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return s, fmt.Errorf("parsing settings file %s: %w", path, err)
+	}
+	for _, rule := range s.SkipRules {
+		if err := rule.Validate(); err != nil {
+			return s, fmt.Errorf("parsing settings file %s: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+// SettingsFromEnv reads the settings given in the environment.
+func SettingsFromEnv() Settings {
+	return Settings{
+		BeamPath:   os.Getenv(BeamPathEnv),
+		GradlePath: os.Getenv(GradlePathEnv),
+		ResultsDir: os.Getenv(ResultsDirEnv),
+		DBPath:     os.Getenv(DBPathEnv),
+		Executor:   os.Getenv(ExecutorEnv),
+		JavaPath:   os.Getenv(JavaPathEnv),
+		NexmarkJar: os.Getenv(NexmarkJarEnv),
+	}
+}
+
+// Merge returns the settings with all non empty fields of o overriding those of s.
+func (s Settings) Merge(o Settings) Settings {
+	if o.BeamPath != "" {
+		s.BeamPath = o.BeamPath
+	}
+	if o.GradlePath != "" {
+		s.GradlePath = o.GradlePath
+	}
+	if o.ResultsDir != "" {
+		s.ResultsDir = o.ResultsDir
+	}
+	if o.DBPath != "" {
+		s.DBPath = o.DBPath
+	}
+	if o.Executor != "" {
+		s.Executor = o.Executor
+	}
+	if o.Runner != "" {
+		s.Runner = o.Runner
+	}
+	if o.JavaPath != "" {
+		s.JavaPath = o.JavaPath
+	}
+	if o.NexmarkJar != "" {
+		s.NexmarkJar = o.NexmarkJar
+	}
+	if o.SkipRules != nil {
+		s.SkipRules = o.SkipRules
+	}
+	return s
+}
+
+// WithDefaults fills in the fields that can be derived from other fields.
+func (s Settings) WithDefaults() Settings {
+	if s.ResultsDir == "" {
+		s.ResultsDir = "results"
+	}
+	if s.DBPath == "" {
+		s.DBPath = filepath.Join(s.ResultsDir, "dbs", "proto.db")
+	}
+	if s.GradlePath == "" && s.BeamPath != "" {
+		s.GradlePath = filepath.Join(s.BeamPath, "gradlew")
+	}
+	if s.Executor == "" {
+		s.Executor = ExecutorGradle
+	}
+	if s.Runner == "" {
+		s.Runner = DefaultNexmarkRunner
+	}
+	if s.JavaPath == "" {
+		s.JavaPath = "java"
+	}
+	if s.SkipRules == nil {
+		s.SkipRules = DefaultSkipRules
+	}
+	return s
+}
+
+// ValidateBeam checks that the settings point at a usable gradle wrapper and Beam checkout.
+func (s Settings) ValidateBeam() error {
+	if s.BeamPath == "" {
+		return fmt.Errorf("no Beam checkout given, use -beam or %s", BeamPathEnv)
+	}
+
+	nexmarkDir := filepath.Join(s.BeamPath, "sdks", "java", "testing", "nexmark")
+	if fi, err := os.Stat(nexmarkDir); err != nil {
+		return fmt.Errorf("%s doesn't look like a Beam checkout: %w", s.BeamPath, err)
+	} else if !fi.IsDir() {
+		return fmt.Errorf("%s doesn't look like a Beam checkout: %s is not a directory", s.BeamPath, nexmarkDir)
+	}
+
+	fi, err := os.Stat(s.GradlePath)
+	if err != nil {
+		return fmt.Errorf("gradle wrapper not found: %w", err)
+	}
+	if fi.IsDir() || fi.Mode()&0111 == 0 {
+		return fmt.Errorf("gradle wrapper %s is not an executable file", s.GradlePath)
+	}
+	return nil
+}
+
+// NewExecutor validates the settings needed by the selected executor and creates it.
+func (s Settings) NewExecutor() (Executor, error) {
+	switch s.Executor {
+	case ExecutorGradle:
+		if err := s.ValidateBeam(); err != nil {
+			return nil, err
+		}
+	case ExecutorJava:
+		if s.NexmarkJar == "" {
+			return nil, fmt.Errorf("the java executor needs a Nexmark jar, use -nexmark-jar or %s", NexmarkJarEnv)
+		}
+		if ok, err := FileExists(s.NexmarkJar); err != nil {
+			return nil, err
+		} else if !ok {
+			return nil, fmt.Errorf("nexmark jar %s doesn't exist", s.NexmarkJar)
+		}
+	}
+	return s.UncheckedExecutor()
+}
+
+// UncheckedExecutor creates the selected executor without checking that it can run, for
+// when it is only used to show what would be run.
+func (s Settings) UncheckedExecutor() (Executor, error) {
+	switch s.Executor {
+	case ExecutorGradle:
+		return &GradleExecutor{
+			GradlePath: s.GradlePath,
+			BeamPath:   s.BeamPath,
+			Runner:     s.Runner,
+		}, nil
+	case ExecutorJava:
+		return &JavaExecutor{
+			JavaPath:   s.JavaPath,
+			NexmarkJar: s.NexmarkJar,
+		}, nil
+	case ExecutorFake:
+		return &FakeExecutor{}, nil
+	default:
+		return nil, fmt.Errorf("unknown executor %q", s.Executor)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// A SkipRule matches benchmarks that shouldn't be run, like queries that are known to
+// take forever. A benchmark matches if each of the fields in Match has one of the listed values.
+type SkipRule struct {
+	// Match maps benchmark field names to a value, or a list of values, the field can have.
+	Match  map[string]interface{} `json:"match"`
+	Reason string                 `json:"reason"`
+}
+
+// DefaultSkipRules are used when no skip rules are configured.
+var DefaultSkipRules = []SkipRule{
+	{
+		Match:  map[string]interface{}{"Query": []interface{}{HighestBidQuery, BoundedSideInputJoinQuery}},
+		Reason: "runs so slowly that it doesn't finish in a reasonable time",
+	},
+}
+
+// benchmarkFields returns the benchmark as the generic values it has in JSON, so that
+// it can be compared with the values in the rules.
+func benchmarkFields(b Benchmark) (map[string]interface{}, error) {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// isBenchmarkField returns true if the benchmark has a field with the given JSON name.
+func isBenchmarkField(name string) bool {
+	t := reflect.TypeOf(Benchmark{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		jname := strings.Split(f.Tag.Get("json"), ",")[0]
+		if jname == "-" {
+			continue
+		}
+		if jname == "" {
+			jname = f.Name
+		}
+		if jname == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that the rule only refers to fields that exist on a benchmark.
+func (r SkipRule) Validate() error {
+	if len(r.Match) == 0 {
+		return fmt.Errorf("skip rule %q matches nothing", r.Reason)
+	}
+
+	for field := range r.Match {
+		if !isBenchmarkField(field) {
+			return fmt.Errorf("skip rule %q: unknown benchmark field %q", r.Reason, field)
+		}
+	}
+	return nil
+}
+
+// Matches returns true if the benchmark fields, as returned by benchmarkFields, match the rule.
+func (r SkipRule) Matches(fields map[string]interface{}) bool {
+	return matchFields(r.Match, fields)
+}
+
+// matchFields returns true if each of the fields in match has one of the listed values.
+func matchFields(match, fields map[string]interface{}) bool {
+	for field, want := range match {
+		alts, ok := want.([]interface{})
+		if !ok {
+			alts = []interface{}{want}
+		}
+
+		found := false
+		for _, alt := range alts {
+			if reflect.DeepEqual(fields[field], alt) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// String describes the rule, mostly for logging.
+func (r SkipRule) String() string {
+	var fields []string
+	for field, want := range r.Match {
+		fields = append(fields, fmt.Sprintf("%s=%v", field, want))
+	}
+	sort.Strings(fields)
+	return strings.Join(fields, ",")
+}
+
+// SkipReason returns the reason of the first rule matching the benchmark, or "" if none do.
+func SkipReason(rules []SkipRule, b Benchmark) (string, error) {
+	if len(rules) == 0 {
+		return "", nil
+	}
+
+	fields, err := benchmarkFields(b)
+	if err != nil {
+		return "", err
+	}
+	for _, rule := range rules {
+		if rule.Matches(fields) {
+			return rule.Reason, nil
+		}
+	}
+	return "", nil
+}
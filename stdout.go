@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A RecoveredPerf is the performance of a query, as far as it could be recovered from the
+// console output of Nexmark.
+type RecoveredPerf struct {
+	// Query is empty if the output didn't tell which query the perf is of.
+	Query string
+	Perf  Perf
+}
+
+var (
+	confDescription = regexp.MustCompile(`^\s*(\d{4})\s+.*\bquery:([A-Z_0-9]+)`)
+	confNumEvents   = regexp.MustCompile(`\bnumEvents:(\d+)`)
+	perfRow         = regexp.MustCompile(`^\s*(\d{4})\s+(.*)$`)
+)
+
+// ParseStdout recovers what it can of the performance of the queries from the console output
+// of Nexmark. It understands the performance table printed at the end of a run, as well as
+// perf and snapshot objects printed as JSON while the queries run. The last perf of each
+// query wins, and the snapshots are added to it.
+func ParseStdout(stdout []byte) []RecoveredPerf {
+	var perfs []RecoveredPerf
+	index := make(map[string]int)
+	perfOf := func(query string) *Perf {
+		i, ok := index[query]
+		if !ok {
+			i = len(perfs)
+			index[query] = i
+			perfs = append(perfs, RecoveredPerf{Query: query})
+		}
+		return &perfs[i].Perf
+	}
+
+	confQueries := make(map[string]string)
+	confEvents := make(map[string]int)
+	var query string
+	inPerfTable := false
+
+	sc := bufio.NewScanner(bytes.NewReader(stdout))
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+
+		if m := confDescription.FindStringSubmatch(line); m != nil {
+			confQueries[m[1]] = m[2]
+			if e := confNumEvents.FindStringSubmatch(line); e != nil {
+				confEvents[m[1]], _ = strconv.Atoi(e[1])
+			}
+			continue
+		}
+		if strings.Contains(line, "Runtime(sec)") {
+			inPerfTable = true
+			continue
+		}
+		if inPerfTable {
+			m := perfRow.FindStringSubmatch(line)
+			if m == nil {
+				inPerfTable = false
+				continue
+			}
+			if nums := tableNumbers(m[2]); len(nums) >= 3 {
+				p := perfOf(confQueries[m[1]])
+				p.RuntimeSec = nums[0]
+				p.EventsPerSec = nums[1]
+				p.NumResults = int(nums[2])
+				// The table has no event count, and the runtime in it is too rounded to
+				// get it from the rate. A run that printed the table generated all the
+				// events it was configured with, unless that was unbounded.
+				if n := confEvents[m[1]]; n > 0 {
+					p.NumEvents = n
+				}
+			}
+			continue
+		}
+
+		if q := consoleQuery(line); q != "" {
+			query = q
+		}
+		if obj := jsonObject(line); obj != nil {
+			var keys map[string]json.RawMessage
+			if json.Unmarshal(obj, &keys) != nil {
+				continue
+			}
+			switch {
+			case keys["snapshots"] != nil && keys["runtimeSec"] != nil:
+				var perf Perf
+				if json.Unmarshal(obj, &perf) == nil {
+					*perfOf(query) = perf
+				}
+			case keys["secSinceStart"] != nil:
+				var snap Snapshots
+				if json.Unmarshal(obj, &snap) == nil {
+					p := perfOf(query)
+					p.Snapshots = append(p.Snapshots, snap)
+					p.NumEvents = snap.NumEvents
+					p.NumResults = snap.NumResults
+					p.RuntimeSec = snap.RuntimeSec
+				}
+			}
+		}
+	}
+	return perfs
+}
+
+// recoveredPerfOf returns the recovered perf of the query. A perf of an unknown query is
+// used if it is the only one recovered.
+func recoveredPerfOf(perfs []RecoveredPerf, query string) *Perf {
+	for i := range perfs {
+		if perfs[i].Query == query {
+			return &perfs[i].Perf
+		}
+	}
+	if len(perfs) == 1 && perfs[0].Query == "" {
+		return &perfs[0].Perf
+	}
+	return nil
+}
+
+// tableNumbers returns the numbers in a row of the performance table, leaving out the
+// baseline comparisons, which are percentages.
+func tableNumbers(row string) []float64 {
+	var nums []float64
+	for _, f := range strings.Fields(row) {
+		if strings.HasSuffix(f, "%") {
+			continue
+		}
+		if v, err := strconv.ParseFloat(f, 64); err == nil {
+			nums = append(nums, v)
+		}
+	}
+	return nums
+}
+
+var consoleQueryName = regexp.MustCompile(`\b(?:RUNNING|DONE|FAILED|CANCELLED|UNKNOWN|STOPPED)\s+([A-Z][A-Z_0-9]*)\b`)
+
+// consoleQuery returns the query named in the job state lines Nexmark prints while monitoring.
+func consoleQuery(line string) string {
+	for _, q := range AllQueries {
+		if strings.Contains(line, "query:"+q) {
+			return q
+		}
+	}
+	if m := consoleQueryName.FindStringSubmatch(line); m != nil {
+		for _, q := range AllQueries {
+			if m[1] == q {
+				return q
+			}
+		}
+	}
+	return ""
+}
+
+// jsonObject returns the JSON object on the line, if there is one.
+func jsonObject(line string) []byte {
+	start := strings.IndexByte(line, '{')
+	end := strings.LastIndexByte(line, '}')
+	if start < 0 || end < start {
+		return nil
+	}
+	obj := []byte(line[start : end+1])
+	if !json.Valid(obj) {
+		return nil
+	}
+	return obj
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func parseStdoutFixture(t *testing.T, name string) []RecoveredPerf {
+	t.Helper()
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "stdout", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ParseStdout(data)
+}
+
+func TestParseStdoutComplete(t *testing.T) {
+	perfs := parseStdoutFixture(t, "complete.txt")
+	if len(perfs) != 1 {
+		t.Fatalf("got %d perfs, want 1: %+v", len(perfs), perfs)
+	}
+
+	// The perf comes from the table, the monitor lines have no numbers in them.
+	p := recoveredPerfOf(perfs, PassthroughQuery)
+	if p == nil {
+		t.Fatalf("no perf for %s, got queries %q", PassthroughQuery, perfs[0].Query)
+	}
+	if p.RuntimeSec != 11.7 || p.EventsPerSec != 84506.4 || p.NumResults != 991683 {
+		t.Errorf("wrong perf: %+v", p)
+	}
+	// The events come from the configuration, not the rounded runtime.
+	if p.NumEvents != 991683 {
+		t.Errorf("got %d events, want 991683", p.NumEvents)
+	}
+	if p.Snapshots != nil {
+		t.Errorf("got snapshots %+v, want none", p.Snapshots)
+	}
+}
+
+func TestParseStdoutUnboundedEvents(t *testing.T) {
+	stdout := []byte(`Configurations:
+  Conf  Description
+  0000  query:PASSTHROUGH; numEvents:0
+Performance:
+  Conf  Runtime(sec)    (Baseline)  Events(/sec)    (Baseline)       Results    (Baseline)
+  0000          11.7                     84506.4                      991683
+`)
+	perfs := ParseStdout(stdout)
+	if len(perfs) != 1 || perfs[0].Query != PassthroughQuery {
+		t.Fatalf("got perfs %+v, want one of %s", perfs, PassthroughQuery)
+	}
+	// Without a bound on the events, the table alone doesn't tell how many there were.
+	if p := perfs[0].Perf; p.RuntimeSec != 11.7 || p.NumEvents != 0 {
+		t.Errorf("got perf %+v, want a runtime of 11.7 and no events", p)
+	}
+}
+
+func TestParseStdoutKilled(t *testing.T) {
+	perfs := parseStdoutFixture(t, "killed.txt")
+	if len(perfs) != 1 || perfs[0].Query != WinningBidsQuery {
+		t.Fatalf("got perfs %+v, want one of %s", perfs, WinningBidsQuery)
+	}
+
+	// The last perf printed before the kill wins.
+	p := perfs[0].Perf
+	if p.RuntimeSec != 10 || p.NumEvents != 415000 || p.EventsPerSec != 41500 {
+		t.Errorf("wrong perf: %+v", p)
+	}
+	want := []Snapshots{
+		{NumEvents: 201000, RuntimeSec: 5, SecSinceStart: 5},
+		{NumEvents: 415000, RuntimeSec: 10, SecSinceStart: 10},
+	}
+	if !reflect.DeepEqual(p.Snapshots, want) {
+		t.Errorf("got snapshots %+v, want %+v", p.Snapshots, want)
+	}
+}
+
+func TestParseStdoutNotRun(t *testing.T) {
+	perfs := parseStdoutFixture(t, "notrun.txt")
+	if len(perfs) != 1 || perfs[0].Query != LocalItemSuggestionQuery {
+		t.Fatalf("got perfs %+v, want one of %s", perfs, LocalItemSuggestionQuery)
+	}
+
+	// The not run row of the table leaves the perf printed before the failure alone.
+	p := perfs[0].Perf
+	if p.RuntimeSec != 5 || p.NumEvents != 143000 || p.NumResults != 5900 {
+		t.Errorf("wrong perf: %+v", p)
+	}
+	if len(p.Snapshots) != 1 {
+		t.Errorf("got %d snapshots, want 1", len(p.Snapshots))
+	}
+}
+
+func TestParseStdoutNothing(t *testing.T) {
+	out := []byte("Exception in thread \"main\" java.lang.IllegalArgumentException: Unknown query\n")
+	if perfs := ParseStdout(out); len(perfs) != 0 {
+		t.Errorf("got perfs %+v from output without any", perfs)
+	}
+}
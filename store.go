@@ -2,10 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -16,37 +20,104 @@ const (
 	StatusOK     = "OK"
 	StatusErr    = "ERR"
 	StatusNotRun = "NOT_RUN"
+	// The benchmark was killed because it ran out of time.
+	StatusTimeout = "TIMEOUT"
+	// The benchmark is being run right now, or the process running it crashed.
+	StatusRunning = "RUNNING"
+	// The benchmark was killed because the run was interrupted. It is run again on the next run.
+	StatusInterrupted = "INTERRUPTED"
+	// The benchmark matched a skip rule. The rules are checked again on the next run.
+	StatusSkipped = "SKIPPED"
+	// The run failed or wrote no results file, but a partial result was recovered from its output.
+	StatusPartial = "PARTIAL"
+	// Reported for benchmarks that were removed from the series by a merge. It is never
+	// stored, the status of the benchmark is kept so that it can be restored.
+	StatusArchived = "ARCHIVED"
 )
 
 var (
-	benchPrefix  = []byte("bench-")
-	statusPrefix = []byte("status-")
-	stdoutPrefix = []byte("stdout-")
-	stderrPrefix = []byte("stderr-")
-	resultPrefix = []byte("result-")
+	benchPrefix    = []byte("bench-")
+	statusPrefix   = []byte("status-")
+	stdoutPrefix   = []byte("stdout-")
+	stderrPrefix   = []byte("stderr-")
+	resultPrefix   = []byte("result-")
+	reasonPrefix   = []byte("reason-")
+	archivedPrefix = []byte("archived-")
+	hashPrefix     = []byte("hash-")
+	reusedPrefix   = []byte("reused-")
+	metaPrefix     = []byte("meta-")
+	// The raw javascript results file of the run.
+	javascriptPrefix = []byte("js-")
+	// Why the run failed, see ClassifyFailure.
+	failurePrefix = []byte("failure-")
+
+	// runPrefixes are the keys that make up the outcome of running a benchmark.
+	runPrefixes = [][]byte{statusPrefix, stdoutPrefix, stderrPrefix, resultPrefix, metaPrefix, javascriptPrefix, failurePrefix}
+
+	scheduleKey = []byte("schedule")
 
 	ErrorSeriesNotFound = errors.New("Series not found")
+	ErrorStoreBusy      = errors.New("the store is in use by another process, like a running series")
 )
 
+// storeLockTimeout is how long opening a store waits for other processes to release it.
+const storeLockTimeout = 5 * time.Second
+
 // A store stores data. The idea is that you have a list of series, which consists of benchmarks.
 type Store struct {
 	logger zerolog.Logger
 	db     *bolt.DB
+
+	// path and lock are only set for stores opened for writing, see NewStore.
+	path string
+	lock *os.File
 }
 
+// NewStore opens the store for writing, creating it if it doesn't exist. Only one process
+// can have a store open for writing, which it keeps until the store is closed.
 func NewStore(logger zerolog.Logger, path string) (*Store, error) {
-	db, err := bolt.Open(path, 0600, nil)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	lock, err := lockStore(path, storeLockTimeout)
+	if err == ErrorStoreBusy {
+		return nil, fmt.Errorf("%w: %s", ErrorStoreBusy, path)
+	} else if err != nil {
+		return nil, err
+	}
+	db, err := openDB(path, &bolt.Options{Timeout: storeLockTimeout})
 	if err != nil {
+		unlockStore(lock)
 		return nil, err
 	}
 
-	// Setup the database
-	err = db.Update(func(tx *bolt.Tx) error {
-		return nil
-	})
+	s := &Store{
+		logger: logger,
+		db:     db,
+		path:   path,
+		lock:   lock,
+	}
+
+	if err := db.Update(s.migrate); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// NewStoreReadOnly opens an existing store for reading only. Nothing is written to the
+// database, and other read only stores can have it open at the same time. Stores that
+// need migrating are refused with ErrorSchemaOutdated.
+func NewStoreReadOnly(logger zerolog.Logger, path string) (*Store, error) {
+	db, err := openDB(path, &bolt.Options{ReadOnly: true, Timeout: storeLockTimeout})
 	if err != nil {
 		return nil, err
 	}
+	if err := db.View(checkSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
 
 	return &Store{
 		logger: logger,
@@ -54,14 +125,57 @@ func NewStore(logger zerolog.Logger, path string) (*Store, error) {
 	}, nil
 }
 
+// openDB opens the database, giving up with ErrorStoreBusy if another process holds it for
+// longer than the timeout of the options.
+func openDB(path string, opts *bolt.Options) (*bolt.DB, error) {
+	db, err := bolt.Open(path, 0600, opts)
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("%w: %s", ErrorStoreBusy, path)
+	}
+	return db, err
+}
+
 func (s *Store) Close() error {
-	return s.db.Close()
+	err := s.db.Close()
+	if s.lock != nil {
+		if uerr := unlockStore(s.lock); err == nil {
+			err = uerr
+		}
+	}
+	return err
+}
+
+// withoutDB runs fn with the database closed, so that read only stores can be opened while
+// it runs, like while a benchmark runs. The writer lock is kept, so no other process can
+// write to the store in the meantime. The store must not be used by fn.
+func (s *Store) withoutDB(fn func()) error {
+	if !canReleaseDB || s.lock == nil {
+		fn()
+		return nil
+	}
+	if err := s.db.Close(); err != nil {
+		return err
+	}
+	fn()
+
+	// Readers only keep the database open for a moment, so wait for them however long it takes.
+	db, err := openDB(s.path, &bolt.Options{})
+	if err != nil {
+		return err
+	}
+	s.db = db
+	return nil
 }
 
-func (s *Store) StoreSeries(id string, benchmarks []Benchmark) error {
+// StoreSeries stores the benchmarks as a new series, overwriting any existing series with
+// the same id. If schedule is not nil, the series is run in the order it gives.
+func (s *Store) StoreSeries(id string, benchmarks []Benchmark, schedule *Schedule) error {
 	logger := s.logger.With().Str("series_key", id).Logger()
 	bid := []byte(id)
 	err := s.db.Update(func(tx *bolt.Tx) error {
+		if err := unindexSeries(tx, id); err != nil {
+			return err
+		}
 		if err := tx.DeleteBucket(bid); err == nil {
 			logger.Warn().Msg("Overwriting series")
 		} else if err != bolt.ErrBucketNotFound {
@@ -86,12 +200,176 @@ func (s *Store) StoreSeries(id string, benchmarks []Benchmark) error {
 				logger.Error().Err(err).Msg("Failed to store benchmark")
 				return err
 			}
+			if err := indexBenchmark(tx, serieBucket, RunRef{Series: id, Bid: i}, b); err != nil {
+				return err
+			}
+		}
+
+		if schedule != nil {
+			data, err := json.Marshal(schedule)
+			if err != nil {
+				return err
+			}
+			if err := serieBucket.Put(scheduleKey, data); err != nil {
+				logger.Error().Err(err).Msg("Failed to store schedule")
+				return err
+			}
 		}
 		return nil
 	})
 	return err
 }
 
+// A MergeSummary counts what happened to the benchmarks when merging into a series.
+type MergeSummary struct {
+	// Kept benchmarks were already in the series, with their results.
+	Kept int
+	// Added benchmarks are new to the series.
+	Added int
+	// Archived benchmarks are no longer part of the series.
+	Archived int
+	// Restored benchmarks had been archived by an earlier merge.
+	Restored int
+}
+
+// MergeSeries updates the series to consist of the given benchmarks without losing results.
+// Benchmarks are matched by the hash of their configuration and which repetition of it they
+// are. Matched benchmarks keep their runs, new ones are added as not run, and those no longer
+// in the series are archived. If the series doesn't exist it is created like StoreSeries.
+func (s *Store) MergeSeries(id string, benchmarks []Benchmark, schedule *Schedule) (MergeSummary, error) {
+	var sum MergeSummary
+	if ok, err := s.HasSeries(id); err != nil {
+		return sum, err
+	} else if !ok {
+		sum.Added = len(benchmarks)
+		return sum, s.StoreSeries(id, benchmarks, schedule)
+	}
+
+	newIDs, err := benchIdentities(benchmarks)
+	if err != nil {
+		return sum, err
+	}
+
+	logger := s.logger.With().Str("series_key", id).Logger()
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		series := tx.Bucket([]byte(id))
+		if series == nil {
+			return ErrorSeriesNotFound
+		}
+
+		// Identify the benchmarks already in the series, archived or not.
+		var oldBids []int
+		var oldBenches []Benchmark
+		c := series.Cursor()
+		for k, v := c.Seek(benchPrefix); k != nil && bytes.HasPrefix(k, benchPrefix); k, v = c.Next() {
+			var bench Benchmark
+			if err := json.Unmarshal(v, &bench); err != nil {
+				return err
+			}
+			oldBids = append(oldBids, btoi(k[len(benchPrefix):]))
+			oldBenches = append(oldBenches, bench)
+		}
+		oldIDs, err := benchIdentities(oldBenches)
+		if err != nil {
+			return err
+		}
+		existing := make(map[benchIdentity]int)
+		nextBid := 0
+		for i, bid := range oldBids {
+			existing[oldIDs[i]] = bid
+			if bid >= nextBid {
+				nextBid = bid + 1
+			}
+		}
+
+		bids := make([]int, len(benchmarks))
+		used := make(map[int]bool)
+		for i, b := range benchmarks {
+			if bid, ok := existing[newIDs[i]]; ok {
+				bids[i] = bid
+				used[bid] = true
+
+				archivedKey := append(archivedPrefix, itob(bid)...)
+				if series.Get(archivedKey) != nil {
+					if err := series.Delete(archivedKey); err != nil {
+						return err
+					}
+					sum.Restored++
+				} else {
+					sum.Kept++
+				}
+				continue
+			}
+
+			data, err := json.Marshal(b)
+			if err != nil {
+				logger.Error().Err(err).Msg("Failed to marshal benchmark")
+				return err
+			}
+			bids[i] = nextBid
+			if err := series.Put(append(benchPrefix, itob(nextBid)...), data); err != nil {
+				logger.Error().Err(err).Msg("Failed to store benchmark")
+				return err
+			}
+			if err := indexBenchmark(tx, series, RunRef{Series: id, Bid: nextBid}, b); err != nil {
+				return err
+			}
+			nextBid++
+			sum.Added++
+		}
+
+		archivedAt := []byte(time.Now().UTC().Format(time.RFC3339))
+		for _, bid := range oldBids {
+			archivedKey := append(archivedPrefix, itob(bid)...)
+			if used[bid] || series.Get(archivedKey) != nil {
+				continue
+			}
+			if err := series.Put(archivedKey, archivedAt); err != nil {
+				return err
+			}
+			sum.Archived++
+		}
+
+		if schedule == nil {
+			return series.Delete(scheduleKey)
+		}
+		// The order of the schedule is in terms of the given benchmarks, make it refer to the bids.
+		merged := *schedule
+		merged.Order = make([]int, len(schedule.Order))
+		for i, idx := range schedule.Order {
+			merged.Order[i] = bids[idx]
+		}
+		data, err := json.Marshal(merged)
+		if err != nil {
+			return err
+		}
+		return series.Put(scheduleKey, data)
+	})
+	return sum, err
+}
+
+// GetSeriesSchedule returns the schedule of the series, or nil if it has none.
+func (s *Store) GetSeriesSchedule(id string) (*Schedule, error) {
+	var schedule *Schedule
+	err := s.db.View(func(tx *bolt.Tx) error {
+		series := tx.Bucket([]byte(id))
+		if series == nil {
+			return ErrorSeriesNotFound
+		}
+
+		data := series.Get(scheduleKey)
+		if data == nil {
+			return nil
+		}
+		schedule = new(Schedule)
+		return json.Unmarshal(data, schedule)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return schedule, nil
+}
+
 // Return true false if series exists
 func (s *Store) HasSeries(id string) (bool, error) {
 	var found bool
@@ -102,6 +380,38 @@ func (s *Store) HasSeries(id string) (bool, error) {
 	return found, err
 }
 
+// ListSeries returns the ids of all the series in the store.
+func (s *Store) ListSeries() ([]string, error) {
+	var ids []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			if isSeriesBucket(name) {
+				ids = append(ids, string(name))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// DeleteSeries removes the series and all of its results from the store.
+func (s *Store) DeleteSeries(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := unindexSeries(tx, id); err != nil {
+			return err
+		}
+		if err := tx.DeleteBucket([]byte(id)); err == bolt.ErrBucketNotFound {
+			return ErrorSeriesNotFound
+		} else if err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
 func (s *Store) GetSeriesBenchmarks(id string) ([]Benchmark, error) {
 	var benches []Benchmark
 	err := s.db.View(func(tx *bolt.Tx) error {
@@ -151,15 +461,186 @@ func (s *Store) GetBenchmarkStatus(sid string, bid int) (string, error) {
 	return out, nil
 }
 
-// RunSeries executes the series and stores the results in the datbase.
-func (s *Store) RunSeries(sid string) error {
+// isArchived returns whether the benchmark was archived by a merge.
+func (s *Store) isArchived(sid string, bid int) (bool, error) {
+	var archived bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		series := tx.Bucket([]byte(sid))
+		if series == nil {
+			return ErrorSeriesNotFound
+		}
+		archived = series.Get(append(archivedPrefix, itob(bid)...)) != nil
+		return nil
+	})
+	return archived, err
+}
+
+// GetSeriesStatuses returns the status of every benchmark in the series, in order.
+func (s *Store) GetSeriesStatuses(sid string) ([]string, error) {
+	var statuses []string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		series := tx.Bucket([]byte(sid))
+		if series == nil {
+			return ErrorSeriesNotFound
+		}
+
+		c := series.Cursor()
+		for k, _ := c.Seek(benchPrefix); k != nil && bytes.HasPrefix(k, benchPrefix); k, _ = c.Next() {
+			bid := k[len(benchPrefix):]
+			if series.Get(append(archivedPrefix, bid...)) != nil {
+				statuses = append(statuses, StatusArchived)
+			} else if v := series.Get(append(statusPrefix, bid...)); v == nil {
+				statuses = append(statuses, StatusNotRun)
+			} else {
+				statuses = append(statuses, string(v))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return statuses, nil
+}
+
+// RunOptions controls how the benchmarks of a series are run.
+type RunOptions struct {
+	Executor Executor
+	// Timeout is the wall clock budget of each benchmark, zero means no limit.
+	Timeout time.Duration
+
+	// SkipRules decide which benchmarks are skipped instead of run.
+	SkipRules []SkipRule
+	// ForceSkipped runs the benchmarks even if they match a skip rule.
+	ForceSkipped bool
+
+	// RunsDir is where each run gets a directory of its own for its artifacts, under
+	// <sid>/<bid>-<start time>. If empty, temporary directories are used.
+	RunsDir string
+
+	// Env is stored with each run as part of its RunMeta.
+	Env RunEnv
+}
+
+// setBenchmarkStatus sets the status of a benchmark.
+func (s *Store) setBenchmarkStatus(sid string, bid int, status string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		series := tx.Bucket([]byte(sid))
+		if series == nil {
+			return ErrorSeriesNotFound
+		}
+		return series.Put(append(statusPrefix, itob(bid)...), []byte(status))
+	})
+}
+
+// skipBenchmark marks the benchmark as skipped for the given reason.
+func (s *Store) skipBenchmark(sid string, bid int, reason string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		series := tx.Bucket([]byte(sid))
+		if series == nil {
+			return ErrorSeriesNotFound
+		}
+		bb := itob(bid)
+		if err := series.Put(append(statusPrefix, bb...), []byte(StatusSkipped)); err != nil {
+			return err
+		}
+		return series.Put(append(reasonPrefix, bb...), []byte(reason))
+	})
+}
+
+// failBenchmark marks the benchmark as failed because of an error of our own, which is kept
+// as the message of its failure. Whatever is stored of the run is removed.
+func (s *Store) failBenchmark(sid string, bid int, cause error) error {
+	failure, err := json.Marshal(Failure{Category: FailureUnknown, Message: cause.Error()})
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		series := tx.Bucket([]byte(sid))
+		if series == nil {
+			return ErrorSeriesNotFound
+		}
+		bb := itob(bid)
+		for _, prefix := range runPrefixes {
+			if err := series.Delete(append(prefix, bb...)); err != nil {
+				return err
+			}
+		}
+		if err := series.Put(append(statusPrefix, bb...), []byte(StatusErr)); err != nil {
+			return err
+		}
+		return series.Put(append(failurePrefix, bb...), failure)
+	})
+}
+
+// resetStaleRunning resets benchmarks left as RUNNING to NOT_RUN. The database is locked
+// while it is open, so these can only be left behind by a process that crashed.
+func (s *Store) resetStaleRunning(sid string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		series := tx.Bucket([]byte(sid))
+		if series == nil {
+			return ErrorSeriesNotFound
+		}
+
+		var stale [][]byte
+		c := series.Cursor()
+		for k, v := c.Seek(statusPrefix); k != nil && bytes.HasPrefix(k, statusPrefix); k, v = c.Next() {
+			if string(v) == StatusRunning {
+				stale = append(stale, append([]byte(nil), k[len(statusPrefix):]...))
+			}
+		}
+
+		for _, bb := range stale {
+			s.logger.Warn().Str("series_key", sid).Int("bid", btoi(bb)).Msg("Resetting stale running benchmark")
+			for _, prefix := range runPrefixes {
+				if err := series.Delete(append(prefix, bb...)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// RunSeries executes the series and stores the results in the datbase. If the context
+// is cancelled, the running benchmark is killed and marked as interrupted.
+func (s *Store) RunSeries(ctx context.Context, sid string, opts RunOptions) error {
+	if err := s.resetStaleRunning(sid); err != nil {
+		return err
+	}
+
 	// We first read in the benchmarks that we need to do.
 	benches, err := s.GetSeriesBenchmarks(sid)
 	if err != nil {
 		return err
 	}
 
-	for bid, bench := range benches {
+	// and the order to do them in.
+	schedule, err := s.GetSeriesSchedule(sid)
+	if err != nil {
+		return err
+	}
+	var order []int
+	if schedule != nil {
+		order = schedule.Order
+	} else {
+		for bid := range benches {
+			order = append(order, bid)
+		}
+	}
+
+	for _, bid := range order {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		bench := benches[bid]
+
+		if archived, err := s.isArchived(sid, bid); err != nil {
+			return err
+		} else if archived {
+			continue
+		}
+
 		status, err := s.GetBenchmarkStatus(sid, bid)
 		if err != nil {
 			return err
@@ -171,23 +652,25 @@ func (s *Store) RunSeries(sid string) error {
 			Msg("Starting benchmark")
 		// fmt.Printf("Bench %d has status: %s\n", bid, status)
 
-		if status != StatusNotRun {
+		if status != StatusNotRun && status != StatusInterrupted && status != StatusSkipped {
 			continue
 		}
 
-		// These run so slowly, that we are excluding them.
-		if bench.Query == HighestBidQuery {
-			continue
-		}
-		if bench.Query == BoundedSideInputJoinQuery {
-			continue
+		if !opts.ForceSkipped {
+			reason, err := SkipReason(opts.SkipRules, bench)
+			if err != nil {
+				return err
+			}
+			if reason != "" {
+				s.logger.Info().Int("bid", bid).Str("reason", reason).Msg("Skipping benchmark")
+				if err := s.skipBenchmark(sid, bid, reason); err != nil {
+					return err
+				}
+				continue
+			}
 		}
-		// if bench.CoderStrategy == "AVRO" && bench.Query == BoundedSideInputJoinQuery {
-		// 	s.logger.Info().Msg("Skipping benchmark")
-		// 	continue
-		// }
 
-		if err := s.RunBenchmark(sid, bid, bench); err != nil {
+		if err := s.RunBenchmark(ctx, sid, bid, bench, opts); err != nil {
 			log.Error().Err(err).Msg("Benchmark errored out")
 		}
 	}
@@ -195,60 +678,238 @@ func (s *Store) RunSeries(sid string) error {
 	return nil
 }
 
-// Run a single benchmark. An error here indicate some process error, not an error in running the benchmark
-func (s *Store) RunBenchmark(sid string, bid int, bench Benchmark) error {
-	tmpDir := os.TempDir()
-	bench.JavascriptFilename = filepath.Join(tmpDir, "flink-jsfile.js")
-	stdout, stderr, merr := bench.Run(s.logger, GradlePath, BeamPath)
-	// We write the
-	if merr != nil {
-		// fmt.Println(stderr)
+// javascriptFilename is where the benchmarks run in the directory are told to write their results.
+func javascriptFilename(dir string) string {
+	return filepath.Join(dir, "results.js")
+}
+
+// newRunDir creates a fresh directory for a run of the benchmark to keep its artifacts in.
+// Without a RunsDir it is a temporary directory.
+func newRunDir(opts RunOptions, sid string, bid int, started time.Time) (string, error) {
+	if opts.RunsDir == "" {
+		return ioutil.TempDir("", fmt.Sprintf("nexbench-%s-%d-", sid, bid))
 	}
 
-	// s.logger.Info().Msg("Running a benchmark")
+	dir := filepath.Join(opts.RunsDir, sid, fmt.Sprintf("%d-%s", bid, started.UTC().Format("20060102T150405.000Z")))
+	return dir, os.MkdirAll(dir, 0755)
+}
 
-	err := s.db.Update(func(tx *bolt.Tx) error {
-		series := tx.Bucket([]byte(sid))
-		if series == nil {
-			return ErrorSeriesNotFound
-		}
-		bb := itob(bid)
+// Run a single benchmark. An error here indicate some process error, not an error in running the
+// benchmark. The benchmark is marked as failed with the error, so that it isn't left as RUNNING.
+func (s *Store) RunBenchmark(ctx context.Context, sid string, bid int, bench Benchmark, opts RunOptions) error {
+	if err := s.setBenchmarkStatus(sid, bid, StatusRunning); err != nil {
+		return err
+	}
 
-		status := StatusOK
-		if merr != nil {
-			status = StatusErr
+	err := s.runBenchmark(ctx, sid, bid, bench, opts)
+	if err != nil {
+		if ferr := s.failBenchmark(sid, bid, err); ferr != nil {
+			s.logger.Error().Err(ferr).Int("bid", bid).Msg("Couldn't mark the benchmark as failed")
 		}
-		series.Put(append(statusPrefix, bb...), []byte(status))
-		series.Put(append(stdoutPrefix, bb...), stdout)
-		series.Put(append(stderrPrefix, bb...), stderr)
+	}
+	return err
+}
 
-		if merr != nil {
-			return nil
+// runBenchmark runs the benchmark, which is marked as RUNNING, and stores the outcome.
+func (s *Store) runBenchmark(ctx context.Context, sid string, bid int, bench Benchmark, opts RunOptions) error {
+	started := time.Now()
+	dir, err := newRunDir(opts, sid, bid, started)
+	if err != nil {
+		return err
+	}
+	bench.JavascriptFilename = javascriptFilename(dir)
+
+	var ex *Execution
+	var merr error
+	err = s.withoutDB(func() {
+		ex, merr = bench.Run(ctx, s.logger, opts.Executor, opts.Timeout)
+	})
+	if err != nil {
+		return err
+	}
+	finished := time.Now()
+
+	meta, err := json.Marshal(RunMeta{
+		RunEnv:     opts.Env,
+		StartedAt:  started.UTC(),
+		FinishedAt: finished.UTC(),
+		WallSec:    finished.Sub(started).Seconds(),
+		ExitCode:   ex.ExitCode,
+		RunDir:     dir,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Keep the raw logs next to the other artifacts, the store has them too.
+	for name, data := range map[string][]byte{"stdout.log": ex.Stdout, "stderr.log": ex.Stderr} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			s.logger.Warn().Err(err).Str("dir", dir).Msg("Couldn't write the logs of the run")
+		}
+	}
+	var javascript []byte
+	if fname, ok := ex.Artifacts[ArtifactJavascript]; ok {
+		if javascript, err = ioutil.ReadFile(fname); err != nil {
+			return err
 		}
+	}
 
+	status := StatusOK
+	if errors.Is(merr, context.DeadlineExceeded) {
+		status = StatusTimeout
+	} else if errors.Is(merr, context.Canceled) {
+		status = StatusInterrupted
+	} else if merr != nil {
+		status = StatusErr
+	}
+
+	var result []byte
+	if status == StatusOK {
 		res, err := bench.AugmentResults(s.logger)
 		if err != nil {
+			s.logger.Error().Err(err).Int("bid", bid).Msg("Couldn't read the results of the benchmark")
+			status = StatusErr
+		} else if result, err = json.Marshal(res); err != nil {
 			return err
 		}
+	}
 
-		data, err := json.Marshal(res)
-		if err != nil {
+	var failure []byte
+	if status == StatusErr || status == StatusTimeout {
+		f := ClassifyFailure(status, ex.Stdout, ex.Stderr)
+		if failure, err = json.Marshal(f); err != nil {
 			return err
 		}
-		series.Put(append(resultPrefix, bb...), data)
+		s.logger.Info().Int("bid", bid).Str("failure", f.Category).Str("exception", f.Exception).Msg("Benchmark failed")
+
+		// Runs that failed, or left no results, may still have printed their progress.
+		if perf := recoveredPerfOf(ParseStdout(ex.Stdout), bench.Query); perf != nil {
+			res := bench.augment(JSResult{Config: Config{Query: bench.Query}, Perf: *perf})
+			res.Recovered = status
+			if result, err = json.Marshal(res); err != nil {
+				return err
+			}
+			s.logger.Info().Int("bid", bid).Str("status", status).Msg("Recovered a partial result from the output")
+			status = StatusPartial
+		}
+	}
 
+	return s.db.Update(func(tx *bolt.Tx) error {
+		series := tx.Bucket([]byte(sid))
+		if series == nil {
+			return ErrorSeriesNotFound
+		}
+
+		bb := itob(bid)
+		series.Put(append(statusPrefix, bb...), []byte(status))
+		series.Delete(append(reasonPrefix, bb...))
+		series.Put(append(stdoutPrefix, bb...), ex.Stdout)
+		series.Put(append(stderrPrefix, bb...), ex.Stderr)
+		series.Put(append(metaPrefix, bb...), meta)
+		if javascript != nil {
+			series.Put(append(javascriptPrefix, bb...), javascript)
+		} else {
+			series.Delete(append(javascriptPrefix, bb...))
+		}
+		if result != nil {
+			series.Put(append(resultPrefix, bb...), result)
+		} else {
+			series.Delete(append(resultPrefix, bb...))
+		}
+		if failure != nil {
+			series.Put(append(failurePrefix, bb...), failure)
+		} else {
+			series.Delete(append(failurePrefix, bb...))
+		}
 		return nil
 	})
-	return err
 }
 
 type Run struct {
 	Bench  Benchmark
 	Status string
+	// Hash identifies the configuration of the benchmark across series, see ConfigHash.
+	Hash string `json:",omitempty"`
+	// Archived is set for benchmarks that a merge removed from the series.
+	Archived bool `json:",omitempty"`
+	// ReusedFrom is the run the results were copied from, if they were reused.
+	ReusedFrom *RunRef `json:",omitempty"`
+	// Meta is set for benchmarks that have run.
+	Meta *RunMeta `json:",omitempty"`
+
+	Result     *Result
+	Stdout     *string
+	Stderr     *string
+	SkipReason *string
+	// Javascript is the raw results file the run wrote.
+	Javascript *string `json:",omitempty"`
+	// Failure is set for runs that failed, including those with partial results.
+	Failure *Failure `json:",omitempty"`
+}
+
+// readRun reads the benchmark with the given bid, and what is known about its run, from the series.
+func (s *Store) readRun(series *bolt.Bucket, bid []byte) (Run, error) {
+	var run Run
+	if err := json.Unmarshal(series.Get(append(benchPrefix, bid...)), &(run.Bench)); err != nil {
+		return run, err
+	}
+	run.Hash = string(series.Get(append(hashPrefix, bid...)))
+	run.Archived = series.Get(append(archivedPrefix, bid...)) != nil
+	if v := series.Get(append(reusedPrefix, bid...)); v != nil {
+		ref := decodeRunRef(v)
+		run.ReusedFrom = &ref
+	}
+
+	statusB := series.Get(append(statusPrefix, bid...))
+	if statusB == nil {
+		run.Status = StatusNotRun
+		return run, nil
+	}
 
-	Result *Result
-	Stdout *string
-	Stderr *string
+	run.Status = string(statusB)
+	if v := series.Get(append(metaPrefix, bid...)); v != nil {
+		var meta RunMeta
+		if err := json.Unmarshal(v, &meta); err != nil {
+			return run, err
+		}
+		run.Meta = &meta
+	}
+	if run.Status == StatusOK || run.Status == StatusPartial {
+		var res Result
+		if err := json.Unmarshal(series.Get(append(resultPrefix, bid...)), &res); err != nil {
+			s.logger.Error().Err(err).Msg("Couldn't get result?")
+			return run, err
+		}
+		run.Result = &res
+	}
+
+	if v := series.Get(append(failurePrefix, bid...)); v != nil {
+		var failure Failure
+		if err := json.Unmarshal(v, &failure); err != nil {
+			return run, err
+		}
+		run.Failure = &failure
+	} else if run.Status == StatusErr || run.Status == StatusTimeout || run.Status == StatusPartial {
+		// Runs from before failures were classified.
+		status := run.Status
+		if run.Result != nil && run.Result.Recovered != "" {
+			status = run.Result.Recovered
+		}
+		failure := ClassifyFailure(status, series.Get(append(stdoutPrefix, bid...)), series.Get(append(stderrPrefix, bid...)))
+		run.Failure = &failure
+	}
+
+	if run.Status == StatusSkipped {
+		run.SkipReason = StrPtr(string(series.Get(append(reasonPrefix, bid...))))
+	}
+
+	if v := series.Get(append(javascriptPrefix, bid...)); v != nil {
+		run.Javascript = StrPtr(string(v))
+	}
+
+	run.Stderr = StrPtr(string(series.Get(append(stderrPrefix, bid...))))
+	run.Stdout = StrPtr(string(series.Get(append(stdoutPrefix, bid...))))
+	return run, nil
 }
 
 func (s *Store) GetSeriesResults(sid string) ([]Run, error) {
@@ -260,31 +921,11 @@ func (s *Store) GetSeriesResults(sid string) ([]Run, error) {
 		}
 
 		c := series.Cursor()
-		for k, v := c.Seek(benchPrefix); k != nil && bytes.HasPrefix(k, benchPrefix); k, v = c.Next() {
-			var run Run
-			bid := k[len(benchPrefix):]
-			if err := json.Unmarshal(v, &(run.Bench)); err != nil {
+		for k, _ := c.Seek(benchPrefix); k != nil && bytes.HasPrefix(k, benchPrefix); k, _ = c.Next() {
+			run, err := s.readRun(series, k[len(benchPrefix):])
+			if err != nil {
 				return err
 			}
-
-			statusB := series.Get(append(statusPrefix, bid...))
-			if statusB == nil {
-				run.Status = StatusNotRun
-			} else {
-				run.Status = string(statusB)
-				if run.Status == StatusOK {
-					var res Result
-					if err := json.Unmarshal(series.Get(append(resultPrefix, bid...)), &res); err != nil {
-						s.logger.Error().Err(err).Msg("Couldn't get result?")
-						return err
-					}
-					run.Result = &res
-				}
-
-				run.Stderr = StrPtr(string(series.Get(append(stderrPrefix, bid...))))
-				run.Stdout = StrPtr(string(series.Get(append(stdoutPrefix, bid...))))
-			}
-
 			runs = append(runs, run)
 		}
 		return nil
@@ -294,3 +935,54 @@ func (s *Store) GetSeriesResults(sid string) ([]Run, error) {
 	}
 	return runs, nil
 }
+
+// RuntimeHistory returns the wall clock durations, in seconds, of all successful runs in the
+// store, grouped by the configuration of the benchmark as given by configKey. Runs from
+// before the RunMeta was stored only have the runtime Nexmark reports, which leaves out
+// gradle and the JVM startup.
+func (s *Store) RuntimeHistory() (map[string][]float64, error) {
+	history := make(map[string][]float64)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, series *bolt.Bucket) error {
+			if !isSeriesBucket(name) {
+				return nil
+			}
+			c := series.Cursor()
+			for k, v := c.Seek(benchPrefix); k != nil && bytes.HasPrefix(k, benchPrefix); k, v = c.Next() {
+				bid := k[len(benchPrefix):]
+				if string(series.Get(append(statusPrefix, bid...))) != StatusOK {
+					continue
+				}
+
+				var bench Benchmark
+				if err := json.Unmarshal(v, &bench); err != nil {
+					return err
+				}
+				var res Result
+				if err := json.Unmarshal(series.Get(append(resultPrefix, bid...)), &res); err != nil {
+					return err
+				}
+
+				key, err := configKey(bench)
+				if err != nil {
+					return err
+				}
+				history[key] = append(history[key], runDuration(series.Get(append(metaPrefix, bid...)), res))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// runDuration returns how long the run with the stored RunMeta and result took, see RuntimeHistory.
+func runDuration(meta []byte, res Result) float64 {
+	var m RunMeta
+	if meta == nil || json.Unmarshal(meta, &m) != nil || m.WallSec <= 0 {
+		return res.Perf.RuntimeSec
+	}
+	return m.WallSec
+}
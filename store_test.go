@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := NewStore(zerolog.Nop(), filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// benchesOf returns a benchmark for each of the queries, so giving a query twice repeats it.
+func benchesOf(queries ...string) []Benchmark {
+	var benches []Benchmark
+	for _, q := range queries {
+		benches = append(benches, Benchmark{FlinkMaster: "[local]", Query: q})
+	}
+	return benches
+}
+
+func runTestSeries(t *testing.T, store *Store, sid string) {
+	t.Helper()
+	err := store.RunSeries(context.Background(), sid, RunOptions{
+		Executor: &FakeExecutor{Perf: Perf{RuntimeSec: 1}},
+		RunsDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mergeTestSeries(t *testing.T, store *Store, sid string, benches []Benchmark, schedule *Schedule) MergeSummary {
+	t.Helper()
+	sum, err := store.MergeSeries(sid, benches, schedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sum
+}
+
+func checkStatuses(t *testing.T, store *Store, sid string, want ...string) {
+	t.Helper()
+	got, err := store.GetSeriesStatuses(sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got statuses %v, want %v", got, want)
+	}
+}
+
+func TestMergeSeriesKeepAddArchiveRestore(t *testing.T) {
+	store := newTestStore(t)
+	a, b, c := PassthroughQuery, SelectionQuery, WinningBidsQuery
+
+	if err := store.StoreSeries("s", benchesOf(a, a, b, b), nil); err != nil {
+		t.Fatal(err)
+	}
+	runTestSeries(t, store, "s")
+
+	sum := mergeTestSeries(t, store, "s", benchesOf(a, a, c), nil)
+	if want := (MergeSummary{Kept: 2, Added: 1, Archived: 2}); sum != want {
+		t.Errorf("got summary %+v, want %+v", sum, want)
+	}
+	checkStatuses(t, store, "s", StatusOK, StatusOK, StatusArchived, StatusArchived, StatusNotRun)
+
+	// Archived benchmarks aren't run, the new one is.
+	runTestSeries(t, store, "s")
+	checkStatuses(t, store, "s", StatusOK, StatusOK, StatusArchived, StatusArchived, StatusOK)
+
+	// Bringing the benchmarks back restores them with their results.
+	sum = mergeTestSeries(t, store, "s", benchesOf(b, a, b, c, a), nil)
+	if want := (MergeSummary{Kept: 3, Restored: 2}); sum != want {
+		t.Errorf("got summary %+v, want %+v", sum, want)
+	}
+	checkStatuses(t, store, "s", StatusOK, StatusOK, StatusOK, StatusOK, StatusOK)
+
+	runs, err := store.GetSeriesResults("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for bid, run := range runs {
+		if run.Archived || run.Result == nil {
+			t.Errorf("bid %d: archived %t, result %v, want a result", bid, run.Archived, run.Result)
+		}
+	}
+}
+
+func TestMergeSeriesRepetitions(t *testing.T) {
+	store := newTestStore(t)
+	a := PassthroughQuery
+
+	if err := store.StoreSeries("s", benchesOf(a, a, a), nil); err != nil {
+		t.Fatal(err)
+	}
+	runTestSeries(t, store, "s")
+
+	// More repetitions keep the ones that ran and add the rest.
+	sum := mergeTestSeries(t, store, "s", benchesOf(a, a, a, a, a), nil)
+	if want := (MergeSummary{Kept: 3, Added: 2}); sum != want {
+		t.Errorf("got summary %+v, want %+v", sum, want)
+	}
+	checkStatuses(t, store, "s", StatusOK, StatusOK, StatusOK, StatusNotRun, StatusNotRun)
+
+	// Fewer archive the last ones.
+	sum = mergeTestSeries(t, store, "s", benchesOf(a, a), nil)
+	if want := (MergeSummary{Kept: 2, Archived: 3}); sum != want {
+		t.Errorf("got summary %+v, want %+v", sum, want)
+	}
+	checkStatuses(t, store, "s", StatusOK, StatusOK, StatusArchived, StatusArchived, StatusArchived)
+
+	sum = mergeTestSeries(t, store, "s", benchesOf(a, a, a, a), nil)
+	if want := (MergeSummary{Kept: 2, Restored: 2}); sum != want {
+		t.Errorf("got summary %+v, want %+v", sum, want)
+	}
+	checkStatuses(t, store, "s", StatusOK, StatusOK, StatusOK, StatusNotRun, StatusArchived)
+}
+
+func TestMergeSeriesSchedule(t *testing.T) {
+	store := newTestStore(t)
+	a, b, c := PassthroughQuery, SelectionQuery, WinningBidsQuery
+
+	if err := store.StoreSeries("s", benchesOf(a, b), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The order is in terms of the merged benchmarks, c is 0, b is 1 and a is 2.
+	merged := benchesOf(c, b, a)
+	schedule := &Schedule{Strategy: ScheduleShuffle, Seed: 42, Order: []int{2, 0, 1}}
+	mergeTestSeries(t, store, "s", merged, schedule)
+
+	stored, err := store.GetSeriesSchedule("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Strategy != ScheduleShuffle || stored.Seed != 42 {
+		t.Errorf("got schedule %s with seed %d, want %s with seed 42", stored.Strategy, stored.Seed, ScheduleShuffle)
+	}
+
+	// a kept bid 0, b bid 1, and c was added as bid 2.
+	if want := []int{0, 2, 1}; !reflect.DeepEqual(stored.Order, want) {
+		t.Fatalf("got order %v, want %v", stored.Order, want)
+	}
+	benches, err := store.GetSeriesBenchmarks("s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, bid := range stored.Order {
+		if got, want := benches[bid].Query, merged[schedule.Order[i]].Query; got != want {
+			t.Errorf("position %d runs %s, want %s", i, got, want)
+		}
+	}
+}
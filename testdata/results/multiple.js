@@ -0,0 +1,14 @@
+var all = [
+  {
+    config: {"auctionSkip":123,"avgAuctionByteSize":500,"avgBidByteSize":100,"avgPersonByteSize":200,"coderStrategy":"HAND","cpuDelayMs":0,"debug":true,"diskBusyBytes":0,"exportSummaryToBigQuery":false,"fanout":5,"firstEventRate":10000,"generateEventFilePathPrefix":null,"hotAuctionRatio":2,"hotBiddersRatio":4,"hotSellersRatio":4,"isRateLimited":false,"maxAuctionsWaitingTime":600,"maxLogEvents":100000,"nextEventRate":10000,"numActivePeople":1000,"numEventGenerators":1,"numEvents":991683,"numInFlightAuctions":100,"occasionalDelaySec":3,"outOfOrderGroupSize":1,"preloadSeconds":0,"probDelayedEvent":0.1,"pubSubMode":"COMBINED","pubsubMessageSerializationMethod":"CODER","query":"PASSTHROUGH","ratePeriodSec":600,"rateShape":"SINE","rateUnit":"PER_SECOND","sessionGap":{"millis":600000,"standardDays":0,"standardHours":0,"standardMinutes":10,"standardSeconds":600},"sideInputNumShards":3,"sideInputRowCount":500,"sideInputType":"DIRECT","sideInputUrl":null,"sinkType":"DEVNULL","sourceType":"DIRECT","streamTimeout":60,"usePubsubPublishTime":false,"useWallclockEventTime":false,"watermarkHoldbackSec":0,"windowPeriodSec":5,"windowSizeSec":10}
+    ,perf: {"errors":null,"eventBytesPerSec":12675965.1,"eventsPerSec":84506.4,"jobId":null,"numEvents":991683,"numResults":991683,"processingDelaySec":-1.0,"resultBytesPerSec":12675965.1,"resultsPerSec":84506.4,"runtimeSec":11.735,"shutdownDelaySec":-1.0,"snapshots":null,"startupDelaySec":-1.0,"timeDilation":-1.0}
+  },
+  {
+    config: {"auctionSkip":123,"avgAuctionByteSize":500,"avgBidByteSize":100,"avgPersonByteSize":200,"coderStrategy":"HAND","cpuDelayMs":0,"debug":true,"diskBusyBytes":0,"exportSummaryToBigQuery":false,"fanout":5,"firstEventRate":10000,"generateEventFilePathPrefix":null,"hotAuctionRatio":2,"hotBiddersRatio":4,"hotSellersRatio":4,"isRateLimited":false,"maxAuctionsWaitingTime":600,"maxLogEvents":100000,"nextEventRate":10000,"numActivePeople":1000,"numEventGenerators":1,"numEvents":991683,"numInFlightAuctions":100,"occasionalDelaySec":3,"outOfOrderGroupSize":1,"preloadSeconds":0,"probDelayedEvent":0.1,"pubSubMode":"COMBINED","pubsubMessageSerializationMethod":"CODER","query":"CURRENCY_CONVERSION","ratePeriodSec":600,"rateShape":"SINE","rateUnit":"PER_SECOND","sessionGap":{"millis":600000,"standardDays":0,"standardHours":0,"standardMinutes":10,"standardSeconds":600},"sideInputNumShards":3,"sideInputRowCount":500,"sideInputType":"DIRECT","sideInputUrl":null,"sinkType":"DEVNULL","sourceType":"DIRECT","streamTimeout":60,"usePubsubPublishTime":false,"useWallclockEventTime":false,"watermarkHoldbackSec":0,"windowPeriodSec":5,"windowSizeSec":10}
+    ,perf: {"errors":null,"eventBytesPerSec":11888782.8,"eventsPerSec":79258.6,"jobId":null,"numEvents":991683,"numResults":908152,"processingDelaySec":-1.0,"resultBytesPerSec":10887372.1,"resultsPerSec":72582.5,"runtimeSec":12.512,"shutdownDelaySec":-1.0,"snapshots":null,"startupDelaySec":-1.0,"timeDilation":-1.0}
+  },
+  {
+    config: {"auctionSkip":123,"avgAuctionByteSize":500,"avgBidByteSize":100,"avgPersonByteSize":200,"coderStrategy":"HAND","cpuDelayMs":0,"debug":true,"diskBusyBytes":0,"exportSummaryToBigQuery":false,"fanout":5,"firstEventRate":10000,"generateEventFilePathPrefix":null,"hotAuctionRatio":2,"hotBiddersRatio":4,"hotSellersRatio":4,"isRateLimited":false,"maxAuctionsWaitingTime":600,"maxLogEvents":100000,"nextEventRate":10000,"numActivePeople":1000,"numEventGenerators":1,"numEvents":991683,"numInFlightAuctions":100,"occasionalDelaySec":3,"outOfOrderGroupSize":1,"preloadSeconds":0,"probDelayedEvent":0.1,"pubSubMode":"COMBINED","pubsubMessageSerializationMethod":"CODER","query":"SELECTION","ratePeriodSec":600,"rateShape":"SINE","rateUnit":"PER_SECOND","sessionGap":{"millis":600000,"standardDays":0,"standardHours":0,"standardMinutes":10,"standardSeconds":600},"sideInputNumShards":3,"sideInputRowCount":500,"sideInputType":"DIRECT","sideInputUrl":null,"sinkType":"DEVNULL","sourceType":"DIRECT","streamTimeout":60,"usePubsubPublishTime":false,"useWallclockEventTime":false,"watermarkHoldbackSec":0,"windowPeriodSec":5,"windowSizeSec":10}
+    ,perf: {"errors":null,"eventBytesPerSec":16471315.5,"eventsPerSec":109808.8,"jobId":null,"numEvents":991683,"numResults":8840,"processingDelaySec":-1.0,"resultBytesPerSec":146827.6,"resultsPerSec":978.9,"runtimeSec":9.031,"shutdownDelaySec":-1.0,"snapshots":null,"startupDelaySec":-1.0,"timeDilation":-1.0}
+  },
+];
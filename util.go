@@ -14,6 +14,14 @@ func StrPtr(a string) *string {
 	return &a
 }
 
+func BoolPtr(a bool) *bool {
+	return &a
+}
+
+func FloatPtr(a float64) *float64 {
+	return &a
+}
+
 // https://stackoverflow.com/a/22467409/712014
 func FileExists(name string) (bool, error) {
 	_, err := os.Stat(name)
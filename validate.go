@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// ValidateReject refuses to store a series with incompatible benchmarks.
+	ValidateReject = "reject"
+	// ValidateDrop removes the incompatible benchmarks from the series.
+	ValidateDrop = "drop"
+	// ValidateWarn stores the series as is, only reporting the incompatible benchmarks.
+	ValidateWarn = "warn"
+)
+
+// An Incompatibility is a combination of options which is known not to work.
+type Incompatibility struct {
+	Name   string
+	Reason string
+	// Check returns true if the benchmark has the incompatibility.
+	Check func(b Benchmark) bool
+}
+
+// Incompatibilities is the registry of combinations known not to work, see notes.md.
+var Incompatibilities = []Incompatibility{
+	{
+		Name:   "java-coder-local-item-suggestion",
+		Reason: "the JAVA coder doesn't work with LOCAL_ITEM_SUGGESTION",
+		Check: func(b Benchmark) bool {
+			return b.CoderStrategy == "JAVA" && b.Query == LocalItemSuggestionQuery
+		},
+	},
+	{
+		Name:   "too-many-events",
+		Reason: fmt.Sprintf("all coders crash above %d events", MAX_EVENTS),
+		Check: func(b Benchmark) bool {
+			return b.NumEvents != nil && *b.NumEvents > MAX_EVENTS
+		},
+	},
+}
+
+// A Violation is a benchmark which has one of the incompatibilities.
+type Violation struct {
+	Index           int
+	Incompatibility Incompatibility
+}
+
+// CheckIncompatibilities returns all the known incompatibilities of the benchmarks.
+func CheckIncompatibilities(benches []Benchmark) []Violation {
+	var vs []Violation
+	for i, b := range benches {
+		for _, inc := range Incompatibilities {
+			if inc.Check(b) {
+				vs = append(vs, Violation{Index: i, Incompatibility: inc})
+			}
+		}
+	}
+	return vs
+}
+
+// ReportViolations logs the violations grouped by incompatibility.
+func ReportViolations(logger zerolog.Logger, level zerolog.Level, vs []Violation) {
+	byName := make(map[string][]int)
+	var order []Incompatibility
+	for _, v := range vs {
+		if _, ok := byName[v.Incompatibility.Name]; !ok {
+			order = append(order, v.Incompatibility)
+		}
+		byName[v.Incompatibility.Name] = append(byName[v.Incompatibility.Name], v.Index)
+	}
+
+	for _, inc := range order {
+		idxs := byName[inc.Name]
+		logger.WithLevel(level).
+			Str("incompatibility", inc.Name).
+			Int("benches", len(idxs)).
+			Str("indices", formatRanges(idxs)).
+			Msg(inc.Reason)
+	}
+}
+
+// ValidateBenchmarks checks the benchmarks against the known incompatibilities and applies
+// the mode, returning the benchmarks that should be stored.
+func ValidateBenchmarks(logger zerolog.Logger, benches []Benchmark, mode string) ([]Benchmark, error) {
+	switch mode {
+	case ValidateReject, ValidateDrop, ValidateWarn:
+	default:
+		return nil, fmt.Errorf("unknown validation mode %q", mode)
+	}
+
+	vs := CheckIncompatibilities(benches)
+	if len(vs) == 0 {
+		return benches, nil
+	}
+
+	switch mode {
+	case ValidateReject:
+		ReportViolations(logger, zerolog.ErrorLevel, vs)
+		return nil, fmt.Errorf("%d benchmarks have known incompatibilities, use -validate=drop to leave them out or -validate=warn to keep them", countBenches(vs))
+	case ValidateDrop:
+		ReportViolations(logger, zerolog.WarnLevel, vs)
+		bad := make(map[int]bool)
+		for _, v := range vs {
+			bad[v.Index] = true
+		}
+		var kept []Benchmark
+		for i, b := range benches {
+			if !bad[i] {
+				kept = append(kept, b)
+			}
+		}
+		logger.Warn().Int("dropped", len(bad)).Msg("Dropped incompatible benchmarks")
+		return kept, nil
+	default: // ValidateWarn
+		ReportViolations(logger, zerolog.WarnLevel, vs)
+		return benches, nil
+	}
+}
+
+func countBenches(vs []Violation) int {
+	seen := make(map[int]bool)
+	for _, v := range vs {
+		seen[v.Index] = true
+	}
+	return len(seen)
+}
+
+// formatRanges formats sorted indices compactly, like 0-9,20-29.
+func formatRanges(idxs []int) string {
+	var parts []string
+	for i := 0; i < len(idxs); {
+		j := i
+		for j+1 < len(idxs) && idxs[j+1] == idxs[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, fmt.Sprintf("%d", idxs[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", idxs[i], idxs[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
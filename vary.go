@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/rs/zerolog"
+)
+
+// Vary runs the mutator once for each of the values, setting the named benchmark field to it.
+// The field can be a value or a pointer, and the values are converted to the type of the field,
+// so the float64s from LinearRange and GeometricRange can be used for int fields. Consecutive
+// floats that round to the same int are only run once, other repeated values are kept.
+func Vary(field string, vals []interface{}) Middleware {
+	return varyField(field, field, vals)
+}
+
+// varyField is Vary, but with the key the field is added to the logger context with.
+func varyField(field, key string, vals []interface{}) Middleware {
+	converted, err := convertFieldValues(field, vals)
+	return func(mut Mutator) Mutator {
+		return func(logger zerolog.Logger, b Benchmark) error {
+			if err != nil {
+				return err
+			}
+			for _, val := range converted {
+				setField(&b, field, val)
+				logger := logger.With().Interface(key, val.Interface()).Logger()
+				if err := mut(logger, b); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+}
+
+// Values is a convenience for building the list of values given to Vary.
+func Values(vals ...interface{}) []interface{} {
+	return vals
+}
+
+// Ints is a convenience for building a list of int values given to Vary.
+func Ints(vals ...int) []interface{} {
+	out := make([]interface{}, len(vals))
+	for i, val := range vals {
+		out[i] = val
+	}
+	return out
+}
+
+// intRange returns start, start+step, ... up to, but not including, end.
+func intRange(start, end, step int) []interface{} {
+	var vals []interface{}
+	if step <= 0 {
+		return vals
+	}
+	for i := start; i < end; i += step {
+		vals = append(vals, i)
+	}
+	return vals
+}
+
+func stringValues(vals []string) []interface{} {
+	out := make([]interface{}, len(vals))
+	for i, val := range vals {
+		out[i] = val
+	}
+	return out
+}
+
+// LinearRange returns start, start+step, ... up to, but not including, end.
+func LinearRange(start, end, step float64) []interface{} {
+	var vals []interface{}
+	if step <= 0 {
+		return vals
+	}
+	for i := 0; ; i++ {
+		v := start + float64(i)*step
+		if v >= end {
+			break
+		}
+		vals = append(vals, v)
+	}
+	return vals
+}
+
+// GeometricRange returns start, start*factor, start*factor^2, ... up to, but not including, end.
+func GeometricRange(start, end, factor float64) []interface{} {
+	var vals []interface{}
+	if start <= 0 || factor <= 1 {
+		return vals
+	}
+	for i := 0; ; i++ {
+		v := start * math.Pow(factor, float64(i))
+		if v >= end {
+			break
+		}
+		vals = append(vals, v)
+	}
+	return vals
+}
+
+// PowersOfTwo returns start, 2*start, 4*start, ... up to, but not including, end.
+func PowersOfTwo(start, end float64) []interface{} {
+	return GeometricRange(start, end, 2)
+}
+
+// PointsPerDecade returns n log spaced values for every factor of ten, starting at start
+// and up to, but not including, end. For n = 4 and start = 100 that is 100, 178, 316, 562, 1000, ...
+func PointsPerDecade(start, end float64, n int) []interface{} {
+	var vals []interface{}
+	if start <= 0 || n <= 0 {
+		return vals
+	}
+	for i := 0; ; i++ {
+		// Computed from start each time, so that the decades land exactly on powers of ten.
+		v := start * math.Pow(10, float64(i)/float64(n))
+		if v >= end*(1-1e-9) {
+			break
+		}
+		vals = append(vals, v)
+	}
+	return vals
+}
+
+// VaryAvgPersonSizeOver sets the average person size to each of the values, which can come
+// from Ints, PowersOfTwo, PointsPerDecade and friends.
+func VaryAvgPersonSizeOver(vals []interface{}) Middleware {
+	return varyField("AveragePersonByteSize", "avgPersonByteSize", vals)
+}
+
+// VaryAvgAuctionSizeOver sets the average auction size to each of the values.
+func VaryAvgAuctionSizeOver(vals []interface{}) Middleware {
+	return varyField("AverageAuctionByteSize", "avgAuctionByteSize", vals)
+}
+
+// VaryAvgBidSizeOver sets the average bid size to each of the values.
+func VaryAvgBidSizeOver(vals []interface{}) Middleware {
+	return varyField("AverageBidByteSize", "avgBidByteSize", vals)
+}
+
+// VaryNumEventsOver sets the number of events to each of the values.
+func VaryNumEventsOver(vals []interface{}) Middleware {
+	return varyField("NumEvents", "numEvents", vals)
+}
+
+// benchmarkField looks up the field by its Go name.
+func benchmarkField(field string) (reflect.StructField, error) {
+	f, ok := reflect.TypeOf(Benchmark{}).FieldByName(field)
+	if !ok {
+		return f, fmt.Errorf("benchmark has no field %q", field)
+	}
+	return f, nil
+}
+
+// convertFieldValues converts the values to the type the field has, or points to. Floats
+// which only became equal to the previous value by being rounded to an int are left out.
+func convertFieldValues(field string, vals []interface{}) ([]reflect.Value, error) {
+	f, err := benchmarkField(field)
+	if err != nil {
+		return nil, err
+	}
+	t := f.Type
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	var out []reflect.Value
+	for i, val := range vals {
+		v, err := convertValue(val, t)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field, err)
+		}
+		if i > 0 && isRounded(vals[i-1], t) && isRounded(val, t) && vals[i-1] != val &&
+			out[len(out)-1].Interface() == v.Interface() {
+			continue
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// isRounded returns true if the value is a float that is rounded when converted to t.
+func isRounded(val interface{}, t reflect.Type) bool {
+	if t.Kind() != reflect.Int && t.Kind() != reflect.Int64 {
+		return false
+	}
+	switch val.(type) {
+	case float32, float64:
+		return true
+	}
+	return false
+}
+
+func convertValue(val interface{}, t reflect.Type) (reflect.Value, error) {
+	v := reflect.ValueOf(val)
+	if !v.IsValid() {
+		return v, fmt.Errorf("can't use nil as a value")
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int64:
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64:
+			return reflect.ValueOf(math.Round(v.Float())).Convert(t), nil
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return v.Convert(t), nil
+		}
+	case reflect.Float64:
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return v.Convert(t), nil
+		}
+	case reflect.String, reflect.Bool:
+		if v.Kind() == t.Kind() {
+			return v.Convert(t), nil
+		}
+	}
+	return v, fmt.Errorf("can't use %v (%T) as %s", val, val, t)
+}
+
+// setField sets the field to the value, which must be of the type of the field or what it
+// points to. Pointer fields get a fresh pointer, so that benchmarks never share one.
+func setField(b *Benchmark, field string, val reflect.Value) {
+	f := reflect.ValueOf(b).Elem().FieldByName(field)
+	if f.Kind() == reflect.Ptr {
+		p := reflect.New(f.Type().Elem())
+		p.Elem().Set(val)
+		f.Set(p)
+	} else {
+		f.Set(val)
+	}
+}